@@ -0,0 +1,243 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/feeds"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// This file writes timelines using the ActivityStreams 2.0 vocabulary
+// (https://www.w3.org/TR/activitystreams-vocabulary/) used by ActivityPub.
+
+const (
+	activityContext = "https://www.w3.org/ns/activitystreams"
+	activityPublic  = "https://www.w3.org/ns/activitystreams#Public"
+)
+
+// activityActor is an ActivityStreams Person describing a user.
+// If it's the top-level object, the user's tweets are embedded in Outbox.
+type activityActor struct {
+	Context           string              `json:"@context,omitempty"`
+	ID                string              `json:"id"`
+	Type              string              `json:"type"`
+	PreferredUsername string              `json:"preferredUsername"`
+	Name              string              `json:"name,omitempty"`
+	Summary           string              `json:"summary,omitempty"`
+	URL               string              `json:"url"`
+	Icon              *activityObject     `json:"icon,omitempty"`
+	Image             *activityObject     `json:"image,omitempty"`
+	Published         *time.Time          `json:"published,omitempty"`
+	Outbox            *activityCollection `json:"outbox,omitempty"`
+}
+
+// activityCollection is an ActivityStreams OrderedCollection of activities.
+type activityCollection struct {
+	Context      string          `json:"@context,omitempty"`
+	ID           string          `json:"id,omitempty"` // empty for combined feeds
+	Type         string          `json:"type"`
+	Name         string          `json:"name,omitempty"`
+	Summary      string          `json:"summary,omitempty"`
+	Updated      time.Time       `json:"updated"`
+	TotalItems   int             `json:"totalItems"`
+	OrderedItems []*activityItem `json:"orderedItems"`
+	LatestID     string          `json:"_latestId,omitempty"` // custom extension used by getFeedLatestID
+}
+
+// activityItem is an ActivityStreams Create (or Announce, for retweets) activity.
+type activityItem struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Actor     string          `json:"actor"`
+	Published time.Time       `json:"published"`
+	To        []string        `json:"to"`
+	Object    *activityObject `json:"object"`
+}
+
+// activityObject is a generic ActivityStreams object, e.g. a Note, Image, or Hashtag.
+type activityObject struct {
+	ID           string            `json:"id,omitempty"`
+	Type         string            `json:"type"`
+	Name         string            `json:"name,omitempty"`
+	Summary      string            `json:"summary,omitempty"`
+	Content      string            `json:"content,omitempty"`
+	ContentMap   map[string]string `json:"contentMap,omitempty"`
+	MediaType    string            `json:"mediaType,omitempty"`
+	URL          string            `json:"url,omitempty"`
+	Href         string            `json:"href,omitempty"`
+	AttributedTo string            `json:"attributedTo,omitempty"`
+	Published    *time.Time        `json:"published,omitempty"`
+	Updated      *time.Time        `json:"updated,omitempty"`
+	To           []string          `json:"to,omitempty"`
+	Sensitive    bool              `json:"sensitive,omitempty"`
+	Tag          []*activityObject `json:"tag,omitempty"`
+	Attachment   []*activityObject `json:"attachment,omitempty"`
+}
+
+// newActivityActor returns a top-level ActivityStreams actor describing prof
+// and containing the supplied outbox (see newActivityCollection).
+func newActivityActor(prof profile, outbox *activityCollection) *activityActor {
+	outbox.Context = "" // moved to the actor
+	act := &activityActor{
+		Context:           activityContext,
+		Outbox:            outbox,
+		ID:                userURL(prof.User),
+		Type:              "Person",
+		PreferredUsername: prof.User,
+		Name:              prof.Name,
+		Summary:           prof.Bio,
+		URL:               userURL(prof.User),
+	}
+	if prof.Image != "" {
+		act.Icon = &activityObject{Type: "Image", URL: prof.Image}
+	}
+	if prof.Banner != "" {
+		act.Image = &activityObject{Type: "Image", URL: prof.Banner}
+	}
+	if !prof.Joined.IsZero() {
+		joined := prof.Joined
+		act.Published = &joined
+	}
+	return act
+}
+
+// newActivityCollection returns a top-level ActivityStreams collection describing feed.
+// profs contains the users whose timelines are in the feed, tweets contains the tweet
+// corresponding to each of feed's items, and ct describes the format of the items' content.
+func newActivityCollection(feed *feeds.Feed, profs []profile, tweets []tweet, ct contentType) *activityCollection {
+	coll := &activityCollection{
+		Context:    activityContext,
+		Type:       "OrderedCollection",
+		Name:       feed.Title,
+		Summary:    feed.Description,
+		Updated:    feed.Updated,
+		TotalItems: len(feed.Items),
+	}
+	if feed.Link.Href != "" {
+		coll.ID = feed.Link.Href + "#outbox"
+	}
+	for i, it := range feed.Items {
+		coll.OrderedItems = append(coll.OrderedItems, newActivityItem(&tweets[i], it, profs, ct))
+	}
+	return coll
+}
+
+// newActivityItem returns an activity describing t. it is t's feed item.
+func newActivityItem(t *tweet, it *feeds.Item, profs []profile, ct contentType) *activityItem {
+	note := &activityObject{
+		ID:           t.Href,
+		Type:         "Note",
+		Content:      it.Content,
+		URL:          t.Href,
+		AttributedTo: userURL(t.User),
+		Published:    &it.Created,
+		To:           []string{activityPublic},
+		Sensitive:    hasString(t.Labels, sensitiveLabel),
+		Attachment:   activityAttachments(t),
+	}
+	switch ct {
+	case textContent:
+		note.MediaType = "text/plain"
+	case markdownContent:
+		note.MediaType = "text/markdown"
+	}
+	if t.Lang != "" && t.Lang != undeterminedLang {
+		note.ContentMap = map[string]string{t.Lang: it.Content}
+	}
+	if !it.Updated.Equal(it.Created) {
+		note.Updated = &it.Updated
+	}
+	if note.Sensitive {
+		note.Summary = labelNames[sensitiveLabel]
+	}
+	for _, h := range t.Hashtags {
+		note.Tag = append(note.Tag, &activityObject{
+			Type: "Hashtag",
+			Name: "#" + h,
+			Href: absoluteURL("/hashtag/" + url.PathEscape(h)),
+		})
+	}
+	for _, m := range t.Mentions {
+		note.Tag = append(note.Tag, &activityObject{Type: "Mention", Name: "@" + m, Href: userURL(m)})
+	}
+
+	// Retweets are represented as the timeline's user announcing (i.e. boosting) the tweet.
+	// This isn't possible in combined feeds since it's unknown who retweeted the tweet.
+	act := &activityItem{
+		ID:        t.Href + "#create",
+		Type:      "Create",
+		Actor:     userURL(t.User),
+		Published: it.Created,
+		To:        []string{activityPublic},
+		Object:    note,
+	}
+	if t.Retweet && len(profs) == 1 {
+		act.ID = t.Href + "#announce-" + strings.ToLower(profs[0].User)
+		act.Type = "Announce"
+		act.Actor = userURL(profs[0].User)
+	}
+	return act
+}
+
+// activityAttachments returns attachments describing the images, videos, and link card in t.
+func activityAttachments(t *tweet) []*activityObject {
+	var atts []*activityObject
+	nodes, err := html.ParseFragment(strings.NewReader(t.Content),
+		&html.Node{Type: html.ElementNode, DataAtom: atom.Body, Data: "body"})
+	if err != nil {
+		return nil
+	}
+	for _, root := range nodes {
+		for _, n := range findNodes(root, func(n *html.Node) bool {
+			return isElement(n, "video") || imageDesc(n) != ""
+		}) {
+			if isElement(n, "video") {
+				src, typ := getAttr(n, "src"), ""
+				if s := findFirstNode(n, matchFunc("source", "src")); src == "" && s != nil {
+					src, typ = getAttr(s, "src"), getAttr(s, "type")
+				}
+				if src != "" {
+					atts = append(atts, &activityObject{Type: "Video", URL: src, MediaType: typ})
+				}
+				continue
+			}
+			att := &activityObject{Type: "Image", URL: getAttr(n, "src"), MediaType: imageMediaType(getAttr(n, "src"))}
+			if alt := getAttr(n, "alt"); alt != defaultImageAlt && alt != defaultVideoAlt {
+				att.Name = alt
+			}
+			atts = append(atts, att)
+		}
+	}
+	if c := t.Card; c != nil && c.URL != "" {
+		atts = append(atts, &activityObject{Type: "Link", Href: c.URL, Name: c.Title})
+	}
+	return atts
+}
+
+// imageMediaType returns the MIME type of the pbs.twimg.com image at u based on its
+// "format" parameter, or an empty string if it's unknown.
+func imageMediaType(u string) string {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	switch pu.Query().Get("format") {
+	case "jpg", "jpeg":
+		return "image/jpeg"
+	case "png":
+		return "image/png"
+	case "gif":
+		return "image/gif"
+	case "webp":
+		return "image/webp"
+	default:
+		return ""
+	}
+}
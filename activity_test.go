@@ -0,0 +1,124 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteFeedActivityStreams(t *testing.T) {
+	prof := profile{User: "user", Name: "User", Bio: "Bio", Image: "https://pbs.twimg.com/profile_images/1/a.jpg"}
+	tweets := []tweet{testTweet("user", 2), testTweet("other", 1)}
+	tweets[0].Lang = "en"
+	tweets[0].Hashtags = []string{"tag"}
+	tweets[0].Mentions = []string{"other"}
+	tweets[0].Labels = []string{sensitiveLabel}
+	tweets[0].Content = `<div>Hi #tag @other<br/>` +
+		`<img src="https://pbs.twimg.com/media/abc?format=jpg&amp;name=small" alt="A dog"/>` +
+		`<video poster="https://pbs.twimg.com/ext_tw_video_thumb/1/pu/img/b.jpg">` +
+		`<source src="https://video.twimg.com/ext_tw_video/1/a.mp4" type="video/mp4"/></video></div>`
+	tweets[0].Card = &linkCard{URL: "https://example.org/", Title: "Example"}
+	tweets[1].Retweet = true
+
+	var b bytes.Buffer
+	opts := feedOptions{pinned: includePinned, contentType: htmlContent}
+	if err := writeFeed(&b, activityFormat, []profile{prof}, tweets, opts); err != nil {
+		t.Fatal("writeFeed failed: ", err)
+	}
+
+	var act activityActor
+	if err := json.Unmarshal(b.Bytes(), &act); err != nil {
+		t.Fatal("Failed unmarshaling actor: ", err)
+	}
+	if act.Context != activityContext || act.Type != "Person" || act.ID != "https://twitter.com/user" ||
+		act.PreferredUsername != "user" || act.Name != "User" || act.Summary != "Bio" ||
+		act.Icon == nil || act.Icon.URL != prof.Image {
+		t.Errorf("Bad actor: %+v", act)
+	}
+	coll := act.Outbox
+	if coll == nil {
+		t.Fatal("Actor doesn't have outbox")
+	}
+	if coll.Context != "" || coll.ID != "https://twitter.com/user#outbox" || coll.Type != "OrderedCollection" || coll.TotalItems != 2 || coll.LatestID != "2" {
+		t.Errorf("Bad outbox: %+v", coll)
+	}
+	if len(coll.OrderedItems) != 2 {
+		t.Fatalf("Outbox has %d item(s); want 2", len(coll.OrderedItems))
+	}
+
+	create := coll.OrderedItems[0]
+	if create.Type != "Create" || create.Actor != "https://twitter.com/user" || !create.Published.Equal(tweets[0].Time) {
+		t.Errorf("Bad first activity: %+v", create)
+	}
+	note := create.Object
+	if note.Type != "Note" || note.ID != tweets[0].Href || note.Content != tweets[0].Content ||
+		note.ContentMap["en"] != tweets[0].Content || !note.Sensitive {
+		t.Errorf("Bad first note: %+v", note)
+	}
+	if diff := cmp.Diff([]*activityObject{
+		{Type: "Hashtag", Name: "#tag", Href: "https://twitter.com/hashtag/tag"},
+		{Type: "Mention", Name: "@other", Href: "https://twitter.com/other"},
+	}, note.Tag); diff != "" {
+		t.Error("Bad tags:\n" + diff)
+	}
+	if diff := cmp.Diff([]*activityObject{
+		{Type: "Image", URL: "https://pbs.twimg.com/media/abc?format=jpg&name=small", MediaType: "image/jpeg", Name: "A dog"},
+		{Type: "Video", URL: "https://video.twimg.com/ext_tw_video/1/a.mp4", MediaType: "video/mp4"},
+		{Type: "Link", Href: "https://example.org/", Name: "Example"},
+	}, note.Attachment); diff != "" {
+		t.Error("Bad attachments:\n" + diff)
+	}
+
+	announce := coll.OrderedItems[1]
+	if announce.Type != "Announce" || announce.Actor != "https://twitter.com/user" ||
+		announce.Object.AttributedTo != "https://twitter.com/other" {
+		t.Errorf("Bad retweet activity: %+v", announce)
+	}
+
+	// Combined feeds should be written as bare collections.
+	b.Reset()
+	profs := []profile{prof, {User: "other", Name: "Other"}}
+	if err := writeFeed(&b, activityFormat, profs, tweets, opts); err != nil {
+		t.Fatal("writeFeed failed: ", err)
+	}
+	var cc activityCollection
+	if err := json.Unmarshal(b.Bytes(), &cc); err != nil {
+		t.Fatal("Failed unmarshaling collection: ", err)
+	}
+	if cc.Context != activityContext || cc.Type != "OrderedCollection" || len(cc.OrderedItems) != 2 {
+		t.Errorf("Bad combined collection: %+v", cc)
+	} else if it := cc.OrderedItems[1]; it.Type != "Create" || it.Actor != "https://twitter.com/other" {
+		t.Errorf("Bad combined retweet activity: %+v", it)
+	}
+
+	// The latest ID should be readable from both types of documents.
+	dir, err := ioutil.TempDir("", "twittuh.activity_test.")
+	if err != nil {
+		t.Fatal("Failed creating temp dir: ", err)
+	}
+	defer os.RemoveAll(dir)
+	for i, ps := range [][]profile{{prof}, profs} {
+		p := filepath.Join(dir, "feed.json")
+		b.Reset()
+		if err := writeFeed(&b, activityFormat, ps, tweets, opts); err != nil {
+			t.Fatal("writeFeed failed: ", err)
+		}
+		if err := ioutil.WriteFile(p, b.Bytes(), 0644); err != nil {
+			t.Fatal("Failed writing feed: ", err)
+		}
+		if id, err := getFeedLatestID(p, activityFormat); err != nil {
+			t.Errorf("getFeedLatestID failed for feed %d: %v", i, err)
+		} else if id != 2 {
+			t.Errorf("getFeedLatestID returned %v for feed %d; want 2", id, i)
+		}
+	}
+}
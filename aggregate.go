@@ -0,0 +1,133 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeline contains a user's profile and the tweets from their timeline.
+type timeline struct {
+	prof     profile
+	tweets   []tweet
+	warnings []parseWarning
+}
+
+// splitUsers splits s, a comma-separated list of users, into bare usernames.
+func splitUsers(s string) []string {
+	var users []string
+	for _, u := range strings.Split(s, ",") {
+		if u = bareUser(strings.TrimSpace(u)); u != "" {
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+// getTimelines calls get for each of the supplied users.
+// If partial is true, failures are logged and their timelines are omitted, and an error is
+// only returned if all of them failed. Otherwise, the first error is returned.
+func getTimelines(users []string, partial bool, get func(user string) (timeline, error)) ([]timeline, error) {
+	var tls []timeline
+	var lastErr error
+	for _, u := range users {
+		tl, err := get(u)
+		if err != nil {
+			if !partial || len(users) == 1 {
+				return nil, err
+			}
+			log.Printf("Failed getting %v: %v", u, err)
+			lastErr = err
+			continue
+		}
+		tls = append(tls, tl)
+	}
+	if len(tls) == 0 {
+		return nil, lastErr
+	}
+	return tls, nil
+}
+
+// timelineProfiles returns the profiles from tls.
+func timelineProfiles(tls []timeline) []profile {
+	profs := make([]profile, len(tls))
+	for i, tl := range tls {
+		profs[i] = tl.prof
+	}
+	return profs
+}
+
+// combineTimelines returns the tweets from tls sorted by descending time and de-duplicated by ID.
+// If a tweet appears in multiple timelines (e.g. because it was retweeted), the copy from its
+// author's own timeline is preferred. Tweets from a single timeline are returned unchanged.
+func combineTimelines(tls []timeline) []tweet {
+	if len(tls) == 1 {
+		return tls[0].tweets
+	}
+	var tweets []tweet
+	idx := make(map[int64]int) // indexes into tweets keyed by ID
+	for _, tl := range tls {
+		for _, t := range tl.tweets {
+			own := strings.EqualFold(t.User, tl.prof.User)
+			if i, ok := idx[t.ID]; ok {
+				if own {
+					tweets[i] = t
+				}
+				continue
+			}
+			idx[t.ID] = len(tweets)
+			tweets = append(tweets, t)
+		}
+	}
+	sort.SliceStable(tweets, func(i, j int) bool {
+		if ti, tj := tweets[i].Time, tweets[j].Time; !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return tweets[i].ID > tweets[j].ID
+	})
+	return tweets
+}
+
+// timelineCache holds recently-fetched timelines so that they can be shared between
+// requests for individual and combined feeds in -serve mode.
+type timelineCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]timelineCacheEntry // keyed by lowercase username
+}
+
+type timelineCacheEntry struct {
+	tl      timeline
+	fetched time.Time
+}
+
+// newTimelineCache returns a new timelineCache that holds timelines for ttl.
+func newTimelineCache(ttl time.Duration) *timelineCache {
+	return &timelineCache{ttl: ttl, entries: make(map[string]timelineCacheEntry)}
+}
+
+// get returns user's cached timeline, if any.
+func (tc *timelineCache) get(user string) (timeline, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	key := strings.ToLower(user)
+	ent, ok := tc.entries[key]
+	if !ok || time.Since(ent.fetched) > tc.ttl {
+		delete(tc.entries, key)
+		return timeline{}, false
+	}
+	return ent.tl, true
+}
+
+// put caches tl as user's timeline.
+func (tc *timelineCache) put(user string, tl timeline) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.entries[strings.ToLower(user)] = timelineCacheEntry{tl, time.Now()}
+}
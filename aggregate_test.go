@@ -0,0 +1,172 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSplitUsers(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"user", []string{"user"}},
+		{"@a, b,,@c ", []string{"a", "b", "c"}},
+	} {
+		if got := splitUsers(tc.in); !cmp.Equal(got, tc.want) {
+			t.Errorf("splitUsers(%q) = %q; want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestGetTimelines(t *testing.T) {
+	errFail := errors.New("failed")
+	get := func(user string) (timeline, error) {
+		if user == "bad" {
+			return timeline{}, errFail
+		}
+		return timeline{prof: profile{User: user}}, nil
+	}
+	for _, tc := range []struct {
+		users   []string
+		partial bool
+		want    []string // users of returned timelines
+		err     error
+	}{
+		{[]string{"a"}, false, []string{"a"}, nil},
+		{[]string{"bad"}, false, nil, errFail},
+		{[]string{"bad"}, true, nil, errFail},
+		{[]string{"a", "b"}, false, []string{"a", "b"}, nil},
+		{[]string{"a", "bad", "b"}, false, nil, errFail},
+		{[]string{"a", "bad", "b"}, true, []string{"a", "b"}, nil},
+		{[]string{"bad", "bad"}, true, nil, errFail},
+	} {
+		tls, err := getTimelines(tc.users, tc.partial, get)
+		var got []string
+		for _, tl := range tls {
+			got = append(got, tl.prof.User)
+		}
+		if !cmp.Equal(got, tc.want) || err != tc.err {
+			t.Errorf("getTimelines(%q, %v) = %q, %v; want %q, %v",
+				tc.users, tc.partial, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestCombineTimelines(t *testing.T) {
+	tw := func(id int64, user string, min int, text string) tweet {
+		t := testTweet(user, id)
+		t.Time, t.Text = testTime.Add(time.Duration(min)*time.Minute), text
+		return t
+	}
+	tls := []timeline{
+		{prof: profile{User: "A"}, tweets: []tweet{
+			tw(5, "a", 5, "a5"),
+			tw(3, "b", 3, "b3 retweeted by a"),
+			tw(1, "a", 1, "a1"),
+		}},
+		{prof: profile{User: "b"}, tweets: []tweet{
+			tw(4, "b", 4, "b4"),
+			tw(3, "b", 3, "b3"),
+			tw(2, "a", 2, "a2 retweeted by b"),
+			tw(6, "b", 1, "b6 posted at same time as a1"),
+		}},
+	}
+	got := combineTimelines(tls)
+	want := []tweet{
+		tw(5, "a", 5, "a5"),
+		tw(4, "b", 4, "b4"),
+		tw(3, "b", 3, "b3"),
+		tw(2, "a", 2, "a2 retweeted by b"),
+		tw(6, "b", 1, "b6 posted at same time as a1"),
+		tw(1, "a", 1, "a1"),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error("combineTimelines returned bad tweets:\n" + diff)
+	}
+
+	// A single timeline should be returned unchanged.
+	if diff := cmp.Diff(tls[1].tweets, combineTimelines(tls[1:])); diff != "" {
+		t.Error("combineTimelines changed single timeline:\n" + diff)
+	}
+}
+
+func TestTimelineCache(t *testing.T) {
+	tc := newTimelineCache(time.Hour)
+	if _, ok := tc.get("user"); ok {
+		t.Error("Empty cache returned timeline")
+	}
+	tl := timeline{prof: profile{User: "User"}}
+	tc.put("User", tl)
+	if got, ok := tc.get("user"); !ok || got.prof != tl.prof {
+		t.Errorf("Cache returned %+v, %v; want %+v, true", got, ok, tl)
+	}
+
+	tc = newTimelineCache(-time.Second)
+	tc.put("user", tl)
+	if _, ok := tc.get("user"); ok {
+		t.Error("Cache returned expired timeline")
+	}
+}
+
+func TestWriteFeedCombined(t *testing.T) {
+	profs := []profile{{User: "a", Name: "A"}, {User: "b", Name: "B"}}
+	tweets := []tweet{testTweet("c", 3), testTweet("b", 2), testTweet("a", 1)}
+	for _, tc := range []struct {
+		title     string
+		wantTitle string
+	}{
+		{"", "A, B"},
+		{"Custom", "Custom"},
+	} {
+		var b bytes.Buffer
+		opts := feedOptions{pinned: includePinned, contentType: htmlContent, title: tc.title,
+			skipUsers: []string{"a", "b", "c"}}
+		if err := writeFeed(&b, jsonFormat, profs, tweets, opts); err != nil {
+			t.Fatal("writeFeed failed: ", err)
+		}
+		jf := readJSONFeed(t, b.Bytes())
+		if jf.Title != tc.wantTitle {
+			t.Errorf("Feed with title %q has title %q; want %q", tc.title, jf.Title, tc.wantTitle)
+		}
+		if want := "Tweets from @a, @b"; jf.Description != want {
+			t.Errorf("Feed has description %q; want %q", jf.Description, want)
+		}
+		// The feed shouldn't link to the first user's timeline.
+		if jf.HomePageURL != "" {
+			t.Errorf("Feed has home page URL %q; want none", jf.HomePageURL)
+		}
+		// Skipped users' tweets should be dropped unless they're from one of the timelines.
+		var got []string
+		for _, it := range jf.Items {
+			got = append(got, it.ID+" "+it.Author.Name)
+		}
+		if diff := cmp.Diff([]string{"2 B (@b)", "1 A (@a)"}, got); diff != "" {
+			t.Error("Feed has bad items:\n" + diff)
+		}
+	}
+
+	// The ActivityStreams collection also shouldn't be identified by the first user's URL.
+	var b bytes.Buffer
+	opts := feedOptions{pinned: includePinned, contentType: htmlContent}
+	if err := writeFeed(&b, activityFormat, profs, tweets, opts); err != nil {
+		t.Fatal("writeFeed failed: ", err)
+	}
+	var coll activityCollection
+	if err := json.Unmarshal(b.Bytes(), &coll); err != nil {
+		t.Fatal("Failed unmarshaling collection: ", err)
+	}
+	if coll.ID != "" {
+		t.Errorf("Collection has ID %q; want none", coll.ID)
+	}
+}
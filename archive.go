@@ -0,0 +1,120 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tweetArchive holds all of the tweets that have been parsed from a user's timeline.
+// Timelines only contain the most-recent tweets, so archives let feeds include tweets
+// that have already scrolled off.
+type tweetArchive struct {
+	User    string  `json:"user"`
+	Profile profile `json:"profile"` // most-recently-parsed profile
+	Tweets  []tweet `json:"tweets"`  // sorted by descending ID
+}
+
+// archiveLimits limits the tweets that are retained in archives so they don't grow forever.
+// Tweets that are still on the timeline are always retained.
+type archiveLimits struct {
+	maxItems int           // if positive, maximum number of tweets to retain
+	maxAge   time.Duration // if positive, maximum age of tweets to retain
+}
+
+// archiveMu serializes updateArchive calls, which may happen concurrently in -serve mode.
+var archiveMu sync.Mutex
+
+// archivePath returns the path of user's archive within dir.
+func archivePath(dir, user string) string {
+	return filepath.Join(dir, strings.ToLower(bareUser(user))+".json")
+}
+
+// readArchive reads the archive at p.
+// If the file does not exist, an empty archive is returned with a nil error.
+func readArchive(p string) (*tweetArchive, error) {
+	var ar tweetArchive
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return &ar, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &ar); err != nil {
+		return nil, err
+	}
+	return &ar, nil
+}
+
+// write atomically writes ar to p.
+func (ar *tweetArchive) write(p string) error {
+	return writeFileAtomic(p, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(ar)
+	})
+}
+
+// merge adds tweets, freshly parsed from the timeline, to ar. Archived copies of tweets
+// are replaced, and archived tweets that aren't in tweets are no longer considered pinned.
+// Archived tweets that aren't in tweets are dropped if they exceed lim.
+func (ar *tweetArchive) merge(tweets []tweet, lim archiveLimits) {
+	ids := make(map[int64]struct{}, len(tweets))
+	merged := append([]tweet(nil), tweets...)
+	for _, t := range tweets {
+		ids[t.ID] = struct{}{}
+	}
+	var old []tweet
+	for _, t := range ar.Tweets {
+		if _, ok := ids[t.ID]; ok {
+			continue
+		}
+		ids[t.ID] = struct{}{}
+		if lim.maxAge > 0 && time.Since(t.Time) > lim.maxAge {
+			continue
+		}
+		t.Pinned = false
+		old = append(old, t)
+	}
+	if lim.maxItems > 0 {
+		sort.SliceStable(old, func(i, j int) bool { return old[i].ID > old[j].ID })
+		if n := lim.maxItems - len(merged); n < len(old) {
+			if n < 0 {
+				n = 0
+			}
+			old = old[:n]
+		}
+	}
+	merged = append(merged, old...)
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].ID > merged[j].ID })
+	ar.Tweets = merged
+}
+
+// updateArchive merges tweets into user's archive in dir (pruning it according to lim)
+// and returns all of the archived tweets. The archived profile is replaced by prof.
+func updateArchive(dir, user string, prof profile, tweets []tweet, lim archiveLimits) ([]tweet, error) {
+	archiveMu.Lock()
+	defer archiveMu.Unlock()
+
+	p := archivePath(dir, user)
+	ar, err := readArchive(p)
+	if err != nil {
+		return nil, err
+	}
+	ar.User = bareUser(user)
+	ar.Profile = prof
+	ar.merge(tweets, lim)
+	if err := ar.write(p); err != nil {
+		return nil, err
+	}
+	debugf("Archive for %v has %v tweet(s)", user, len(ar.Tweets))
+	return ar.Tweets, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUpdateArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "twittuh.archive_test.")
+	if err != nil {
+		t.Fatal("Failed creating temp dir: ", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tw := func(id int64, text string, pinned bool) tweet {
+		t := testTweet("user", id)
+		t.Text, t.Pinned, t.Hashtags = text, pinned, []string{"tag"}
+		return t
+	}
+
+	// The first update should just return the supplied tweets.
+	got, err := updateArchive(dir, "@User", profile{User: "User"}, []tweet{tw(1, "pinned", true), tw(3, "c", false), tw(2, "b", false)}, archiveLimits{})
+	if err != nil {
+		t.Fatal("updateArchive failed: ", err)
+	}
+	if diff := cmp.Diff([]int64{3, 2, 1}, tweetIDs(got)); diff != "" {
+		t.Error("First update returned bad tweets:\n" + diff)
+	}
+
+	// The second update should return old tweets in addition to the new ones, with newly-parsed
+	// versions replacing archived ones. The no-longer-present tweet should be unpinned.
+	got, err = updateArchive(dir, "user", profile{User: "User", Name: "Name"}, []tweet{tw(4, "d", false), tw(3, "c2", false)}, archiveLimits{})
+	if err != nil {
+		t.Fatal("updateArchive failed: ", err)
+	}
+	want := []tweet{tw(4, "d", false), tw(3, "c2", false), tw(2, "b", false), tw(1, "pinned", false)}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error("Second update returned bad tweets:\n" + diff)
+	}
+
+	ar, err := readArchive(archivePath(dir, "USER"))
+	if err != nil {
+		t.Fatal("readArchive failed: ", err)
+	}
+	if ar.User != "user" {
+		t.Errorf("Archive has user %q; want %q", ar.User, "user")
+	}
+	if want := (profile{User: "User", Name: "Name"}); ar.Profile != want {
+		t.Errorf("Archive has profile %+v; want %+v", ar.Profile, want)
+	}
+	if diff := cmp.Diff(want, ar.Tweets); diff != "" {
+		t.Error("Archive contains bad tweets:\n" + diff)
+	}
+}
+
+func TestArchiveMergeLimits(t *testing.T) {
+	now := time.Now()
+	tw := func(id int64, age time.Duration) tweet {
+		t := testTweet("user", id)
+		t.Time = now.Add(-age)
+		return t
+	}
+	const day = 24 * time.Hour
+	for _, tc := range []struct {
+		lim  archiveLimits
+		want []int64
+	}{
+		{archiveLimits{}, []int64{6, 5, 4, 3, 2, 1}},
+		{archiveLimits{maxAge: 5 * day / 2}, []int64{6, 5, 4, 3}},
+		{archiveLimits{maxItems: 3}, []int64{6, 5, 4}},
+		{archiveLimits{maxItems: 1}, []int64{6, 5}}, // tweets on the timeline are retained
+		{archiveLimits{maxItems: 5, maxAge: 3 * day / 2}, []int64{6, 5, 4}},
+	} {
+		ar := tweetArchive{Tweets: []tweet{tw(4, day), tw(3, 2*day), tw(2, 3*day), tw(1, 4*day)}}
+		ar.merge([]tweet{tw(6, 0), tw(5, 0)}, tc.lim)
+		if diff := cmp.Diff(tc.want, tweetIDs(ar.Tweets)); diff != "" {
+			t.Errorf("merge with %+v produced bad tweets:\n%s", tc.lim, diff)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// cardLayout describes how Twitter displays a link card.
+type cardLayout string
+
+const (
+	smallCard cardLayout = "small" // thumbnail beside text
+	largeCard cardLayout = "large" // wide image above text
+)
+
+// linkCard describes a link card (i.e. a preview of an outbound link) attached to a tweet.
+type linkCard struct {
+	URL         string     `json:"url"`                   // destination (t.co unless expanded)
+	Title       string     `json:"title"`                 // page title
+	Description string     `json:"description,omitempty"` // page description
+	Domain      string     `json:"domain,omitempty"`      // e.g. "nih.gov"
+	Image       string     `json:"image,omitempty"`       // thumbnail image URL
+	Layout      cardLayout `json:"layout"`
+}
+
+// improveLinkCard looks for a link card in n, an embed. If it finds one, it replaces
+// the card with simplified HTML and returns the card and the new HTML's title link
+// (nil if the card doesn't have a link).
+func improveLinkCard(n *html.Node) (*linkCard, *html.Node) {
+	cn := findFirstNode(n, func(n *html.Node) bool {
+		for _, s := range rules.LinkCards {
+			if s.match(n) {
+				return true
+			}
+		}
+		return false
+	})
+	if cn == nil {
+		return nil, nil
+	}
+
+	card := linkCard{Layout: smallCard}
+	if strings.Contains(strings.ToLower(getAttr(cn, "data-testid")), "large") {
+		card.Layout = largeCard
+	}
+
+	// The details contain divs with the title, an optional description, and the domain.
+	var texts []string
+	for c := cn.FirstChild; c != nil; c = c.NextSibling {
+		if t := getText(c, true); t != "" {
+			texts = append(texts, t)
+		}
+	}
+	switch len(texts) {
+	case 0:
+		return nil, nil
+	case 1:
+		card.Title = texts[0]
+	case 2:
+		card.Title, card.Domain = texts[0], texts[1]
+	default:
+		card.Title, card.Description, card.Domain = texts[0], texts[1], texts[len(texts)-1]
+	}
+
+	// The details are wrapped in a link to the destination.
+	for p := cn.Parent; p != nil && p != n; p = p.Parent {
+		if isElement(p, "a") {
+			card.URL = absoluteURL(getAttr(p, "href"))
+			cn = p
+			break
+		}
+	}
+
+	// The thumbnail lives in a separate branch of the card (also wrapped in a link, if
+	// the card has one), so walk up until we find it.
+	wrapper := cn
+	for p := cn.Parent; p != nil && p != n; p = p.Parent {
+		img := findFirstNode(p, func(n *html.Node) bool {
+			return isElement(n, "img") && isCardImage(getAttr(n, "src"))
+		})
+		if img != nil {
+			card.Image = getAttr(img, "src")
+		}
+		if img != nil || (card.URL != "" && len(findNodes(p, func(n *html.Node) bool {
+			return isElement(n, "a") && absoluteURL(getAttr(n, "href")) == card.URL
+		})) > 1) {
+			wrapper = p
+			break
+		}
+	}
+
+	p := card.render()
+	replaceNode(p, wrapper)
+	return &card, findFirstNode(p, matchFunc("a", "href"))
+}
+
+// render returns a <p> element containing an HTML representation of c.
+func (c *linkCard) render() *html.Node {
+	elem := func(a atom.Atom, attrs ...html.Attribute) *html.Node {
+		return &html.Node{Type: html.ElementNode, DataAtom: a, Data: a.String(), Attr: attrs}
+	}
+	text := func(s string) *html.Node { return &html.Node{Type: html.TextNode, Data: s} }
+	// Some cards (e.g. for videos) don't have links.
+	link := func(child *html.Node) *html.Node {
+		if c.URL == "" {
+			return child
+		}
+		a := elem(atom.A, html.Attribute{Key: "href", Val: c.URL})
+		a.AppendChild(child)
+		return a
+	}
+
+	// A paragraph is used since simplifyContent promotes the children of attribute-less
+	// divs, and lines are separated by <br> for the same reason.
+	p := elem(atom.P)
+	line := func(children ...*html.Node) {
+		if p.FirstChild != nil {
+			p.AppendChild(elem(atom.Br))
+		}
+		for _, ch := range children {
+			p.AppendChild(ch)
+		}
+	}
+	if c.Image != "" {
+		line(link(elem(atom.Img, html.Attribute{Key: "alt", Val: ""}, html.Attribute{Key: "src", Val: c.Image})))
+	}
+	bold := elem(atom.B)
+	bold.AppendChild(text(c.Title))
+	line(link(bold))
+	if c.Description != "" {
+		line(text(c.Description))
+	}
+	if c.Domain != "" {
+		italic := elem(atom.I)
+		italic.AppendChild(text(c.Domain))
+		line(italic)
+	}
+	return p
+}
+
+// isCardImage returns true if u is a link card thumbnail.
+func isCardImage(u string) bool {
+	pu, err := url.Parse(u)
+	return err == nil && pu.Host == "pbs.twimg.com" && strings.HasPrefix(pu.Path, "/card_img/")
+}
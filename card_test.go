@@ -0,0 +1,83 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/html"
+)
+
+func TestImproveLinkCard(t *testing.T) {
+	const (
+		link  = "https://t.co/abc"
+		thumb = "https://pbs.twimg.com/card_img/123/abc?format=jpg&amp;name=small"
+	)
+	for _, tc := range []struct {
+		desc string
+		orig string
+		card *linkCard
+		want string
+	}{
+		{
+			"large",
+			`<div><div data-testid="card.wrapper">` +
+				`<div data-testid="card.layoutLarge.media"><a href="` + link + `"><div><img alt="" src="` + thumb + `"/></div></a></div>` +
+				`<div><a href="` + link + `"><div data-testid="card.layoutLarge.detail">` +
+				`<div><span>Title</span></div><div><span>Description</span></div><div><svg></svg><span>example.org</span></div>` +
+				`</div></a></div></div></div>`,
+			&linkCard{URL: link, Title: "Title", Description: "Description", Domain: "example.org",
+				Image: strings.ReplaceAll(thumb, "&amp;", "&"), Layout: largeCard},
+			`<div><p><a href="` + link + `"><img alt="" src="` + thumb + `"/></a><br/>` +
+				`<a href="` + link + `"><b>Title</b></a><br/>Description<br/><i>example.org</i></p></div>`,
+		},
+		{
+			"small without description",
+			`<div><div data-testid="card.wrapper">` +
+				`<div data-testid="card.layoutSmall.media"><a href="` + link + `"><div></div></a></div>` +
+				`<div><a href="` + link + `"><div data-testid="card.layoutSmall.detail">` +
+				`<div><span>Title</span></div><div><span>example.org</span></div>` +
+				`</div></a></div></div></div>`,
+			&linkCard{URL: link, Title: "Title", Domain: "example.org", Layout: smallCard},
+			`<div><p><a href="` + link + `"><b>Title</b></a><br/><i>example.org</i></p></div>`,
+		},
+		{
+			"no link",
+			`<div><div><div role="button"><img alt="" src="` + thumb + `"/></div>` +
+				`<div><div role="button"><div data-testid="card.layoutSmall.detail">` +
+				`<div><span>Video</span></div><div><span>Watch this</span></div><div><span>example.org</span></div>` +
+				`</div></div></div></div></div>`,
+			&linkCard{Title: "Video", Description: "Watch this", Domain: "example.org",
+				Image: strings.ReplaceAll(thumb, "&amp;", "&"), Layout: smallCard},
+			`<div><p><img alt="" src="` + thumb + `"/><br/><b>Video</b><br/>Watch this<br/><i>example.org</i></p></div>`,
+		},
+		{
+			"no card",
+			`<div><div>Something else</div></div>`,
+			nil,
+			`<div><div>Something else</div></div>`,
+		},
+	} {
+		root, err := html.Parse(strings.NewReader(tc.orig))
+		if err != nil {
+			t.Fatalf("%s: failed parsing %q: %v", tc.desc, tc.orig, err)
+		}
+		embed := findFirstNode(root, matchFunc("body")).FirstChild
+		card, _ := improveLinkCard(embed)
+		if diff := cmp.Diff(tc.card, card); diff != "" {
+			t.Errorf("%s: bad card:\n%s", tc.desc, diff)
+		}
+		var b bytes.Buffer
+		if err := html.Render(&b, embed); err != nil {
+			t.Fatal("Failed rendering tree: ", err)
+		}
+		if got := b.String(); got != tc.want {
+			t.Errorf("%s: improveLinkCard produced %q; want %q", tc.desc, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/feeds"
+)
+
+// emailOptions describes how email digests (see emailFormat) are addressed and sent.
+type emailOptions struct {
+	from     string   // sender address, e.g. "twittuh <twittuh@example.org>"
+	to       []string // recipient addresses
+	smtpAddr string   // SMTP server as "host:port"; required unless digests are written to stdout
+	smtpUser string   // username for SMTP PLAIN authentication; if empty, no auth is used
+	smtpPass string   // password for SMTP PLAIN authentication
+}
+
+// latestIDHeader is the message header used to record the greatest tweet ID in a digest.
+// It's read by getFeedLatestID.
+const latestIDHeader = "X-Twittuh-Latest-Id"
+
+// errEmptyDigest is returned by writeDigest when an email digest would contain no tweets.
+var errEmptyDigest = errors.New("no new tweets for digest")
+
+// digestItem holds a tweet as rendered into an email digest.
+type digestItem struct {
+	Href   string
+	Author string
+	Time   string
+	Text   string
+	HTML   htmltemplate.HTML
+}
+
+// digestTmpl renders the HTML part of an email digest.
+var digestTmpl = htmltemplate.Must(htmltemplate.New("").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body>
+<h2>{{if .Link}}<a href="{{.Link}}">{{.Title}}</a>{{else}}{{.Title}}{{end}}</h2>
+{{- range .Items}}
+<div style="border-top: 1px solid #ccc; padding: 1em 0;">
+<div style="color: #666; font-size: smaller;">{{.Author}} - <a href="{{.Href}}">{{.Time}}</a></div>
+<div>{{.HTML}}</div>
+</div>
+{{- end}}
+</body>
+</html>
+`))
+
+// threadLatestID returns the greatest ID in t, including self-replies merged into it.
+func threadLatestID(t *tweet) int64 {
+	latest := t.ID
+	for _, id := range t.Thread {
+		if id > latest {
+			latest = id
+		}
+	}
+	return latest
+}
+
+// writeDigest writes an email digest containing the tweets that would be included in a feed
+// but haven't been sent yet, i.e. whose threads have IDs greater than opts.oldLatestID.
+// The oldest unsent tweets are chosen first, so if opts.maxItems is reached, the remaining
+// tweets are left for the next digest. The greatest included ID is recorded in the message.
+func writeDigest(w io.Writer, profs []profile, tweets []tweet, opts feedOptions) error {
+	maxItems := opts.maxItems
+	opts.maxItems = 0
+	feed, itemTweets, err := buildFeed(profs, tweets, opts)
+	if err != nil {
+		return err
+	}
+
+	var unsent []tweet
+	for _, t := range itemTweets {
+		if threadLatestID(&t) <= opts.oldLatestID {
+			debugf("Skipping %v: already sent", t.ID)
+			continue
+		}
+		unsent = append(unsent, t)
+	}
+	sort.SliceStable(unsent, func(i, j int) bool { return unsent[i].ID < unsent[j].ID })
+	if maxItems > 0 && len(unsent) > maxItems {
+		unsent = unsent[:maxItems]
+	}
+
+	var sentID int64
+	for i := range unsent {
+		if id := threadLatestID(&unsent[i]); id > sentID {
+			sentID = id
+		}
+	}
+	debugf("Writing digest with %v tweet(s) and latest ID %v", len(unsent), sentID)
+
+	// Tweets are listed newest-first.
+	sort.SliceStable(unsent, func(i, j int) bool { return unsent[i].ID > unsent[j].ID })
+	return writeEmail(w, feed, profs[0], unsent, sentID, opts)
+}
+
+// writeEmail writes a MIME message to w containing an HTML and plain-text digest of tweets,
+// using feed's title and link. latestID is recorded in latestIDHeader.
+// errEmptyDigest is returned if tweets is empty.
+func writeEmail(w io.Writer, feed *feeds.Feed, prof profile, tweets []tweet, latestID int64,
+	opts feedOptions) error {
+	if len(tweets) == 0 {
+		return errEmptyDigest
+	}
+	if opts.email.from == "" || len(opts.email.to) == 0 {
+		return errors.New("email sender and recipients must be supplied")
+	}
+	from, err := mail.ParseAddress(opts.email.from)
+	if err != nil {
+		return fmt.Errorf("bad sender %q: %v", opts.email.from, err)
+	}
+	to, err := mail.ParseAddressList(strings.Join(opts.email.to, ","))
+	if err != nil {
+		return fmt.Errorf("bad recipients %q: %v", strings.Join(opts.email.to, ","), err)
+	}
+	var toStrs []string
+	for _, a := range to {
+		toStrs = append(toStrs, a.String())
+	}
+
+	var items []digestItem
+	var text bytes.Buffer
+	fmt.Fprintln(&text, feed.Title)
+	if feed.Link.Href != "" {
+		fmt.Fprintln(&text, feed.Link.Href)
+	}
+	for _, t := range tweets {
+		it := digestItem{
+			Href:   t.Href,
+			Author: t.displayName(),
+			Time:   t.Time.UTC().Format("2006-01-02 15:04 MST"),
+		}
+		for _, ct := range []contentType{textContent, htmlContent} {
+			content, err := t.renderContent(ct)
+			if err != nil {
+				return fmt.Errorf("failed rendering %v: %v", t.ID, err)
+			}
+			if _, content, err = opts.templates.itemText(&t, prof, content, ct); err != nil {
+				return fmt.Errorf("failed executing templates for %v: %v", t.ID, err)
+			}
+			if ct == htmlContent {
+				if content, err = sanitizeHTML(content); err != nil {
+					return fmt.Errorf("failed sanitizing %v: %v", t.ID, err)
+				}
+				it.HTML = htmltemplate.HTML(content)
+			} else {
+				it.Text = content
+			}
+		}
+		items = append(items, it)
+		fmt.Fprintf(&text, "\n----\n%v - %v\n%v\n\n%v\n", it.Author, it.Time, it.Href, strings.TrimSpace(it.Text))
+	}
+
+	mw := multipart.NewWriter(w)
+	hdr := []struct{ name, val string }{
+		{"From", from.String()},
+		{"To", strings.Join(toStrs, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", fmt.Sprintf("%v: %d new tweet(s)", feed.Title, len(tweets)))},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{latestIDHeader, fmt.Sprint(latestID)},
+		{"Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": mw.Boundary()})},
+	}
+	for _, h := range hdr {
+		if _, err := fmt.Fprintf(w, "%s: %s\r\n", h.name, h.val); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(w, "\r\n"); err != nil {
+		return err
+	}
+
+	// Parts are ordered from least to most preferred.
+	for _, p := range []struct {
+		typ   string
+		write func(w io.Writer) error
+	}{
+		{"text/plain", func(w io.Writer) error { _, err := w.Write(text.Bytes()); return err }},
+		{"text/html", func(w io.Writer) error {
+			return digestTmpl.Execute(w, struct {
+				Title, Link string
+				Items       []digestItem
+			}{feed.Title, feed.Link.Href, items})
+		}},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.typ + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return err
+		}
+		qw := quotedprintable.NewWriter(pw)
+		if err := p.write(qw); err != nil {
+			return err
+		}
+		if err := qw.Close(); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// sendEmail sends msg, a message written by writeEmail, via the SMTP server in opts.
+func sendEmail(opts emailOptions, msg []byte) error {
+	from, err := mail.ParseAddress(opts.from)
+	if err != nil {
+		return fmt.Errorf("bad sender %q: %v", opts.from, err)
+	}
+	to, err := mail.ParseAddressList(strings.Join(opts.to, ","))
+	if err != nil {
+		return fmt.Errorf("bad recipients %q: %v", strings.Join(opts.to, ","), err)
+	}
+	var rcpts []string
+	for _, a := range to {
+		rcpts = append(rcpts, a.Address)
+	}
+	var auth smtp.Auth
+	if opts.smtpUser != "" {
+		host, _, err := net.SplitHostPort(opts.smtpAddr)
+		if err != nil {
+			return err
+		}
+		// smtp.PlainAuth refuses to send credentials without TLS unless the server is local.
+		auth = smtp.PlainAuth("", opts.smtpUser, opts.smtpPass, host)
+	}
+	debugf("Sending digest to %v via %v", strings.Join(rcpts, ","), opts.smtpAddr)
+	return smtp.SendMail(opts.smtpAddr, auth, from.Address, rcpts, msg)
+}
@@ -0,0 +1,306 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// readDigest parses msg, written by writeEmail, and returns its headers and
+// the decoded bodies of its parts keyed by content type.
+func readDigest(t *testing.T, msg []byte) (mail.Header, map[string]string) {
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal("Failed reading message: ", err)
+	}
+	mt, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mt != "multipart/alternative" {
+		t.Fatalf("Bad Content-Type %q (%v)", m.Header.Get("Content-Type"), err)
+	}
+	parts := make(map[string]string)
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		b, err := ioutil.ReadAll(p) // quoted-printable is decoded automatically
+		if err != nil {
+			t.Fatal("Failed reading part: ", err)
+		}
+		pt, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		parts[pt] = string(b)
+	}
+	return m.Header, parts
+}
+
+func TestWriteFeedEmail(t *testing.T) {
+	tweets := []tweet{testTweet("user", 5), testTweet("user", 4), testTweet("user", 3)}
+	tweets[1].Content += `<script>alert(1)</script>`
+	opts := feedOptions{
+		pinned:      includePinned,
+		contentType: htmlContent,
+		oldLatestID: 3,
+		email: emailOptions{
+			from: "twittuh <twittuh@example.org>",
+			to:   []string{"a@example.org", "B <b@example.org>"},
+		},
+	}
+	var b bytes.Buffer
+	if err := writeFeed(&b, emailFormat, []profile{{User: "user", Name: "Name"}}, tweets, opts); err != nil {
+		t.Fatal("writeFeed failed: ", err)
+	}
+	hdr, parts := readDigest(t, b.Bytes())
+
+	subj, err := new(mime.WordDecoder).DecodeHeader(hdr.Get("Subject"))
+	if err != nil {
+		t.Errorf("Failed decoding subject %q: %v", hdr.Get("Subject"), err)
+	}
+	for name, want := range map[string]string{
+		"From":         `"twittuh" <twittuh@example.org>`,
+		"To":           `<a@example.org>, "B" <b@example.org>`,
+		latestIDHeader: "5",
+	} {
+		if got := hdr.Get(name); got != want {
+			t.Errorf("%v header is %q; want %q", name, got, want)
+		}
+	}
+	if want := "Name (@user): 2 new tweet(s)"; subj != want {
+		t.Errorf("Subject is %q; want %q", subj, want)
+	}
+
+	// Only tweets newer than oldLatestID should be included.
+	for typ, want := range map[string][]string{
+		"text/plain": {"Tweet 5", "Tweet 4", "https://twitter.com/user/status/5"},
+		"text/html":  {"Tweet <b>5</b>", "Tweet <b>4</b>", `href="https://twitter.com/user/status/4"`},
+	} {
+		body, ok := parts[typ]
+		if !ok {
+			t.Errorf("No %v part", typ)
+			continue
+		}
+		for _, w := range want {
+			if !strings.Contains(body, w) {
+				t.Errorf("%v part doesn't contain %q:\n%s", typ, w, body)
+			}
+		}
+		if strings.Contains(body, "status/3") {
+			t.Errorf("%v part contains already-sent tweet:\n%s", typ, body)
+		}
+	}
+	if strings.Contains(parts["text/html"], "alert(1)") {
+		t.Errorf("HTML part contains unsanitized content:\n%s", parts["text/html"])
+	}
+
+	// getFeedLatestID should read the ID back from a saved digest.
+	dir, err := ioutil.TempDir("", "twittuh.email_test.")
+	if err != nil {
+		t.Fatal("Failed creating temp dir: ", err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "digest.eml")
+	if err := ioutil.WriteFile(p, b.Bytes(), 0644); err != nil {
+		t.Fatal("Failed writing digest: ", err)
+	}
+	if id, err := getFeedLatestID(p, emailFormat); err != nil || id != 5 {
+		t.Errorf("getFeedLatestID(%q, %q) = %v, %v; want 5, nil", p, emailFormat, id, err)
+	}
+
+	// If there are no new tweets, errEmptyDigest should be returned.
+	opts.oldLatestID = 5
+	if err := writeFeed(&b, emailFormat, []profile{{User: "user"}}, tweets, opts); !errors.Is(err, errEmptyDigest) {
+		t.Errorf("writeFeed with no new tweets returned %v; want %v", err, errEmptyDigest)
+	}
+
+	// Other formats shouldn't skip tweets that were already sent.
+	b.Reset()
+	if err := writeFeed(&b, jsonFormat, []profile{{User: "user"}}, tweets, opts); err != nil {
+		t.Fatal("writeFeed failed: ", err)
+	}
+	var ids []string
+	for _, it := range readJSONFeed(t, b.Bytes()).Items {
+		ids = append(ids, it.ID)
+	}
+	if diff := cmp.Diff([]string{"5", "4", "3"}, ids); diff != "" {
+		t.Error("JSON feed has bad items:\n" + diff)
+	}
+}
+
+func TestWriteFeedEmailMaxItems(t *testing.T) {
+	tweets := []tweet{testTweet("user", 5), testTweet("user", 4), testTweet("user", 3)}
+	opts := feedOptions{
+		pinned:      includePinned,
+		contentType: htmlContent,
+		maxItems:    2,
+		email:       emailOptions{from: "twittuh@example.org", to: []string{"a@example.org"}},
+	}
+	// Each digest should include the oldest unsent tweets and record the latest one
+	// so that tweets omitted due to maxItems are sent later.
+	for _, tc := range []struct {
+		oldLatestID int64
+		want        []int64 // tweets in digest
+		latestID    string
+	}{
+		{0, []int64{4, 3}, "4"},
+		{4, []int64{5}, "5"},
+	} {
+		opts.oldLatestID = tc.oldLatestID
+		var b bytes.Buffer
+		if err := writeFeed(&b, emailFormat, []profile{{User: "user"}}, tweets, opts); err != nil {
+			t.Fatal("writeFeed failed: ", err)
+		}
+		hdr, parts := readDigest(t, b.Bytes())
+		if got := hdr.Get(latestIDHeader); got != tc.latestID {
+			t.Errorf("Digest after %v has %v %q; want %q", tc.oldLatestID, latestIDHeader, got, tc.latestID)
+		}
+		var got []int64
+		for _, id := range []int64{5, 4, 3} {
+			if strings.Contains(parts["text/plain"], fmt.Sprintf("status/%d", id)) {
+				got = append(got, id)
+			}
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("Digest after %v has bad tweets:\n%s", tc.oldLatestID, diff)
+		}
+	}
+}
+
+// smtpSession holds data received by fakeSMTPServer.
+type smtpSession struct {
+	Auth  string // decoded "\x00user\x00pass" from AUTH PLAIN
+	From  string
+	Rcpts []string
+	Data  string
+}
+
+// fakeSMTPServer is a minimal local SMTP server that accepts a single message.
+type fakeSMTPServer struct {
+	ln   net.Listener
+	done chan smtpSession
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed listening: ", err)
+	}
+	srv := &fakeSMTPServer{ln: ln, done: make(chan smtpSession, 1)}
+	go srv.serve()
+	return srv
+}
+
+func (srv *fakeSMTPServer) addr() string { return srv.ln.Addr().String() }
+func (srv *fakeSMTPServer) close()       { srv.ln.Close() }
+
+func (srv *fakeSMTPServer) serve() {
+	conn, err := srv.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	tc := textproto.NewConn(conn)
+	var sess smtpSession
+	tc.PrintfLine("220 localhost ESMTP")
+	for {
+		ln, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.Fields(ln + " ")[0])
+		arg := strings.TrimSpace(ln[len(cmd):])
+		switch cmd {
+		case "EHLO", "HELO":
+			tc.PrintfLine("250-localhost")
+			tc.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			if f := strings.Fields(arg); len(f) == 2 {
+				b, _ := base64.StdEncoding.DecodeString(f[1])
+				sess.Auth = string(b)
+			}
+			tc.PrintfLine("235 OK")
+		case "MAIL":
+			sess.From = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<> ")
+			tc.PrintfLine("250 OK")
+		case "RCPT":
+			sess.Rcpts = append(sess.Rcpts, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<> "))
+			tc.PrintfLine("250 OK")
+		case "DATA":
+			tc.PrintfLine("354 Go ahead")
+			b, err := tc.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			sess.Data = string(b)
+			tc.PrintfLine("250 OK")
+		case "QUIT":
+			tc.PrintfLine("221 Bye")
+			srv.done <- sess
+			return
+		default:
+			tc.PrintfLine("250 OK")
+		}
+	}
+}
+
+func TestSendEmail(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	defer srv.close()
+
+	opts := emailOptions{
+		from:     "twittuh <twittuh@example.org>",
+		to:       []string{"a@example.org", "B <b@example.org>"},
+		smtpAddr: srv.addr(),
+		smtpUser: "user",
+		smtpPass: "pass",
+	}
+	var b bytes.Buffer
+	fopts := feedOptions{pinned: includePinned, contentType: htmlContent, email: opts}
+	if err := writeFeed(&b, emailFormat, []profile{{User: "user"}}, []tweet{testTweet("user", 2), testTweet("user", 1)}, fopts); err != nil {
+		t.Fatal("writeFeed failed: ", err)
+	}
+	if err := sendEmail(opts, b.Bytes()); err != nil {
+		t.Fatal("sendEmail failed: ", err)
+	}
+
+	var sess smtpSession
+	select {
+	case sess = <-srv.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for message")
+	}
+	want := smtpSession{
+		Auth:  "\x00user\x00pass",
+		From:  "twittuh@example.org",
+		Rcpts: []string{"a@example.org", "b@example.org"},
+	}
+	got := sess
+	got.Data = ""
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error("Bad SMTP session:\n" + diff)
+	}
+	hdr, parts := readDigest(t, []byte(sess.Data))
+	if id := hdr.Get(latestIDHeader); id != "2" {
+		t.Errorf("Sent message has %v %q; want %q", latestIDHeader, id, "2")
+	}
+	if !strings.Contains(parts["text/html"], "Tweet <b>2</b>") {
+		t.Errorf("Sent message lacks tweet:\n%s", parts["text/html"])
+	}
+}
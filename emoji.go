@@ -0,0 +1,113 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// emojiPathRegexp matches the path of a Twemoji image URL and extracts the emoji's
+// dash-separated code points. Twitter serves both SVG and PNG variants, e.g.
+// "/emoji/v2/svg/1f449.svg", "/emoji/v2/72x72/1f4aa-1f3fe.png", and the Twemoji CDN uses
+// paths like "/v/13.0.1/72x72/1f600.png" and "/2/svg/2764.svg".
+var emojiPathRegexp = regexp.MustCompile(`/(?:svg|\d+x\d+)/([0-9a-f]+(?:-[0-9a-f]+)*)\.(?:svg|png)$`)
+
+// emojiCodePoints returns the dash-separated code points from u if it is a Twemoji image URL.
+// An empty string is returned otherwise.
+func emojiCodePoints(u string) string {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case strings.HasSuffix(pu.Host, ".twimg.com"):
+		if !strings.HasPrefix(pu.Path, "/emoji/") {
+			return "" // e.g. hashflags, which aren't Unicode emoji
+		}
+	case strings.Contains(pu.Host, "twemoji"), strings.Contains(pu.Path, "/twemoji"):
+	default:
+		return ""
+	}
+	m := emojiPathRegexp.FindStringSubmatch(pu.Path)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// emojiText returns the emoji displayed by img, a Twemoji <img> element.
+// The URL gives us the individual code points in the emoji (including 200d for ZWJ),
+// but if it can't be parsed, the image's alt text is used instead. An empty string is
+// returned if img doesn't appear to be an emoji.
+func emojiText(img *html.Node) string {
+	src := getAttr(img, "src")
+	if cps := emojiCodePoints(src); cps != "" {
+		var text string
+		for _, s := range strings.Split(cps, "-") {
+			v, err := strconv.ParseUint(s, 16, 64)
+			if err != nil || v > unicode.MaxRune {
+				debugf("Invalid code point %q in %q", s, src)
+				text = ""
+				break
+			}
+			text += string(rune(v))
+		}
+		if text != "" {
+			return text
+		}
+	}
+	return strings.TrimSpace(getAttr(img, "alt"))
+}
+
+// isEmojiBackground returns true if n is one of the divs that Twitter uses to display
+// emoji as background images alongside their <img> elements.
+func isEmojiBackground(n *html.Node) bool {
+	if !isElement(n, "div") || n.FirstChild != nil {
+		return false
+	}
+	st := getAttr(n, "style")
+	return strings.Contains(st, "background-image:") && strings.Contains(st, "/emoji/")
+}
+
+// normalizeEmoji replaces emoji images under root with text nodes containing the emoji
+// themselves. It should be called before reading names, quoted tweet headers, link card
+// titles, and tweet text, all of which can contain emoji.
+func normalizeEmoji(root *html.Node) {
+	// Emoji in tweet text are placed within divs for no good reason as far as I can tell.
+	// We need to replace the outer divs so that we don't start a new block in the HTML.
+	for _, n := range findNodes(root, rules.Emoji.match) {
+		var text string
+		if img := findFirstNode(n, matchFunc("img")); img != nil {
+			text = emojiText(img)
+		}
+		if text == "" {
+			text = strings.TrimSpace(getAttr(n, "aria-label"))
+		}
+		if text != "" {
+			replaceNode(&html.Node{Type: html.TextNode, Data: text}, n)
+		}
+	}
+
+	// Emoji elsewhere (e.g. in names) may just be bare <img> elements,
+	// possibly preceded by background divs.
+	for _, img := range findNodes(root, func(n *html.Node) bool {
+		return isElement(n, "img") && emojiCodePoints(getAttr(n, "src")) != ""
+	}) {
+		text := emojiText(img)
+		if text == "" {
+			continue
+		}
+		if prev := img.PrevSibling; prev != nil && isEmojiBackground(prev) {
+			prev.Parent.RemoveChild(prev)
+		}
+		replaceNode(&html.Node{Type: html.TextNode, Data: text}, img)
+	}
+}
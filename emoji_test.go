@@ -0,0 +1,80 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestEmojiCodePoints(t *testing.T) {
+	for _, tc := range []struct{ url, want string }{
+		{"https://abs-0.twimg.com/emoji/v2/svg/1f449.svg", "1f449"},
+		{"https://abs.twimg.com/emoji/v2/svg/1f4aa-1f3fe.svg", "1f4aa-1f3fe"},
+		{"https://abs.twimg.com/emoji/v2/72x72/1f600.png", "1f600"},
+		{"https://twemoji.maxcdn.com/v/13.0.1/72x72/2764.png", "2764"},
+		{"https://twemoji.maxcdn.com/2/svg/1f1fa-1f1f8.svg", "1f1fa-1f1f8"},
+		{"https://cdn.jsdelivr.net/gh/twitter/twemoji@14.0.2/assets/svg/1f600.svg", "1f600"},
+		{"https://abs.twimg.com/hashflags/Foo/Foo.png", ""},
+		{"https://pbs.twimg.com/media/svg/1f600.png", ""},
+		{"https://example.org/svg/1f600.svg", ""},
+	} {
+		if got := emojiCodePoints(tc.url); got != tc.want {
+			t.Errorf("emojiCodePoints(%q) = %q; want %q", tc.url, got, tc.want)
+		}
+	}
+}
+
+func TestNormalizeEmoji(t *testing.T) {
+	const (
+		svg = "https://abs-0.twimg.com/emoji/v2/svg/1f4aa-1f3fe.svg"
+		png = "https://abs-0.twimg.com/emoji/v2/72x72/2764.png"
+	)
+	for _, tc := range []struct{ orig, want string }{
+		{ // tweet text
+			`<span>Hi</span><div aria-label="💪🏾" style="height: 1.2em;">` +
+				`<div style="background-image: url(&quot;` + svg + `&quot;);"></div>` +
+				`<img alt="💪🏾" src="` + svg + `"/></div><span>there</span>`,
+			`<span>Hi</span>💪🏾<span>there</span>`,
+		},
+		{ // bare image in a name
+			`<span>Jane <img alt="❤️" src="` + png + `"/></span>`,
+			`<span>Jane ❤</span>`,
+		},
+		{ // background div preceding bare image
+			`<span>A<div style="background-image: url(&quot;` + png + `&quot;);"></div>` +
+				`<img alt="❤️" src="` + png + `"/></span>`,
+			`<span>A❤</span>`,
+		},
+		{ // unparsable URL falls back to alt text
+			`<div aria-label="🦄" style="height: 1.2em;"><img alt="🦄" src="https://example.org/u.png"/></div>`,
+			`🦄`,
+		},
+		{ // hashflags are left alone
+			`<img alt="" src="https://abs.twimg.com/hashflags/Foo/Foo.png"/>`,
+			`<img alt="" src="https://abs.twimg.com/hashflags/Foo/Foo.png"/>`,
+		},
+	} {
+		root, err := html.Parse(strings.NewReader(tc.orig))
+		if err != nil {
+			t.Fatalf("Failed parsing %q: %v", tc.orig, err)
+		}
+		body := findFirstNode(root, matchFunc("body"))
+		normalizeEmoji(body)
+
+		var b bytes.Buffer
+		for c := body.FirstChild; c != nil; c = c.NextSibling {
+			if err := html.Render(&b, c); err != nil {
+				t.Fatal("Failed rendering tree: ", err)
+			}
+		}
+		if got := b.String(); got != tc.want {
+			t.Errorf("normalizeEmoji(%q) = %q; want %q", tc.orig, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/gorilla/feeds"
+)
+
+// The feeds package only supports a single (malformed, in the case of Atom) category
+// per item and doesn't support per-item languages, so the types here wrap its structs
+// to add the missing data. Fields in the outer structs take precedence over
+// identically-named fields in the embedded ones.
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	*feeds.AtomEntry
+	Lang       string         `xml:"xml:lang,attr,omitempty"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomFeed struct {
+	*feeds.AtomFeed
+	Entries []*atomEntry `xml:"entry"`
+}
+
+func (f *atomFeed) FeedXml() interface{} { return f }
+
+// newAtomFeed returns an Atom representation of feed.
+// tweets contains the tweet corresponding to each of feed's items,
+// and ct describes the format of the items' content.
+func newAtomFeed(feed *feeds.Feed, tweets []tweet, ct contentType) *atomFeed {
+	af := &atomFeed{AtomFeed: (&feeds.Atom{Feed: feed}).AtomFeed()}
+	for i, e := range af.AtomFeed.Entries {
+		if e.Content != nil && ct != htmlContent {
+			e.Content.Type = "text"
+		}
+		ae := &atomEntry{AtomEntry: e, Lang: tweets[i].Lang}
+		for _, c := range tweets[i].categories() {
+			ae.Categories = append(ae.Categories, atomCategory{c})
+		}
+		af.Entries = append(af.Entries, ae)
+	}
+	return af
+}
+
+type rssItem struct {
+	*feeds.RssItem
+	Categories []string `xml:"category"`
+}
+
+type rssChannel struct {
+	*feeds.RssFeed
+	Items []*rssItem `xml:"item"`
+}
+
+type rssFeed struct {
+	XMLName          xml.Name `xml:"rss"`
+	Version          string   `xml:"version,attr"`
+	ContentNamespace string   `xml:"xmlns:content,attr"`
+	Channel          *rssChannel
+}
+
+func (f *rssFeed) FeedXml() interface{} { return f }
+
+// newRSSFeed returns an RSS representation of feed.
+// tweets contains the tweet corresponding to each of feed's items,
+// and ct describes the format of the items' content.
+func newRSSFeed(feed *feeds.Feed, tweets []tweet, ct contentType) *rssFeed {
+	rf := (&feeds.Rss{Feed: feed}).RssFeed()
+	ch := &rssChannel{RssFeed: rf}
+	for i, it := range rf.Items {
+		// content:encoded must contain HTML, so put other formats in the description instead.
+		if it.Content != nil && ct != htmlContent {
+			it.Description = it.Content.Content
+			it.Content = nil
+		}
+		ch.Items = append(ch.Items, &rssItem{RssItem: it, Categories: tweets[i].categories()})
+	}
+	// RSS only supports a single language per channel.
+	rf.Language = uniformLang(tweets)
+	return &rssFeed{
+		Version:          "2.0",
+		ContentNamespace: "http://purl.org/rss/1.0/modules/content/",
+		Channel:          ch,
+	}
+}
+
+type jsonItem struct {
+	*feeds.JSONItem
+	Language string    `json:"language,omitempty"`
+	LinkCard *linkCard `json:"_link_card,omitempty"` // custom extension
+}
+
+type jsonFeed struct {
+	*feeds.JSONFeed
+	Items []*jsonItem `json:"items,omitempty"`
+}
+
+// jsonFeedVersion is the version of the JSON Feed spec that added the "language" field.
+const jsonFeedVersion = "https://jsonfeed.org/version/1.1"
+
+// newJSONFeed returns a JSON Feed representation of feed.
+// tweets contains the tweet corresponding to each of feed's items,
+// and ct describes the format of the items' content.
+func newJSONFeed(feed *feeds.Feed, tweets []tweet, ct contentType) *jsonFeed {
+	jf := &jsonFeed{JSONFeed: (&feeds.JSON{Feed: feed}).JSONFeed()}
+	jf.Version = jsonFeedVersion
+	for i, it := range jf.JSONFeed.Items {
+		if ct != htmlContent {
+			it.ContentText, it.ContentHTML = it.ContentHTML, ""
+		}
+		it.Tags = tweets[i].categories()
+		ji := &jsonItem{JSONItem: it, Language: tweets[i].Lang, LinkCard: tweets[i].Card}
+		if c := tweets[i].Card; c != nil && c.Image != "" {
+			it.Image = c.Image
+		}
+		jf.Items = append(jf.Items, ji)
+	}
+	return jf
+}
+
+// uniformLang returns the language shared by all of tweets, or an empty string if
+// they use different languages. Tweets in undetermined languages (e.g. just links)
+// are ignored.
+func uniformLang(tweets []tweet) string {
+	var lang string
+	for _, t := range tweets {
+		if t.Lang == "" || t.Lang == undeterminedLang {
+			continue
+		}
+		if lang == "" {
+			lang = t.Lang
+		} else if !strings.EqualFold(t.Lang, lang) {
+			return ""
+		}
+	}
+	return lang
+}
+
+// categories returns the categories (or tags) that should be attached to t's feed item.
+func (t *tweet) categories() []string {
+	return appendUnique(append([]string(nil), t.Hashtags...), t.Labels...)
+}
@@ -0,0 +1,258 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/gorilla/feeds"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// testFeed returns a feed containing an item for each of tweets.
+func testFeed(tweets []tweet) *feeds.Feed {
+	feed := &feeds.Feed{
+		Title:   "Feed",
+		Link:    &feeds.Link{Href: "https://twitter.com/user"},
+		Updated: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for _, t := range tweets {
+		feed.Add(&feeds.Item{
+			Title:   t.Text,
+			Link:    &feeds.Link{Href: t.Href},
+			Id:      t.Href,
+			Created: t.Time,
+		})
+	}
+	return feed
+}
+
+func TestFeedCategories(t *testing.T) {
+	tweets := []tweet{
+		{Href: "https://twitter.com/user/status/3", Text: "a", Hashtags: []string{"foo", "bar"}},
+		{Href: "https://twitter.com/user/status/2", Text: "b", Hashtags: []string{"foo"}, Labels: []string{sensitiveLabel}},
+		{Href: "https://twitter.com/user/status/1", Text: "c"},
+	}
+	want := [][]string{{"foo", "bar"}, {"foo", sensitiveLabel}, nil}
+	feed := testFeed(tweets)
+
+	// Unmarshal the written feeds into minimal structs containing just the categories.
+	var b bytes.Buffer
+	if err := feeds.WriteXML(newAtomFeed(feed, tweets, htmlContent), &b); err != nil {
+		t.Fatal("Failed writing Atom feed: ", err)
+	}
+	var af struct {
+		Entries []struct {
+			Categories []struct {
+				Term string `xml:"term,attr"`
+			} `xml:"category"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(b.Bytes(), &af); err != nil {
+		t.Fatal("Failed unmarshaling Atom feed: ", err)
+	}
+	var got [][]string
+	for _, e := range af.Entries {
+		var cats []string
+		for _, c := range e.Categories {
+			cats = append(cats, c.Term)
+		}
+		got = append(got, cats)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error("Bad Atom categories:\n" + diff)
+	}
+
+	b.Reset()
+	if err := feeds.WriteXML(newRSSFeed(feed, tweets, htmlContent), &b); err != nil {
+		t.Fatal("Failed writing RSS feed: ", err)
+	}
+	var rf struct {
+		Items []struct {
+			Categories []string `xml:"category"`
+		} `xml:"channel>item"`
+	}
+	if err := xml.Unmarshal(b.Bytes(), &rf); err != nil {
+		t.Fatal("Failed unmarshaling RSS feed: ", err)
+	}
+	got = nil
+	for _, it := range rf.Items {
+		got = append(got, it.Categories)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error("Bad RSS categories:\n" + diff)
+	}
+
+	jf := newJSONFeed(feed, tweets, htmlContent)
+	got = nil
+	for _, it := range jf.Items {
+		got = append(got, it.Tags)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error("Bad JSON tags:\n" + diff)
+	}
+}
+
+func TestFeedLang(t *testing.T) {
+	tweets := []tweet{
+		{Href: "https://twitter.com/user/status/3", Text: "a", Lang: "en"},
+		{Href: "https://twitter.com/user/status/2", Text: "b", Lang: "und"},
+		{Href: "https://twitter.com/user/status/1", Text: "c", Lang: "en"},
+	}
+	feed := testFeed(tweets)
+
+	var b bytes.Buffer
+	if err := feeds.WriteXML(newAtomFeed(feed, tweets, htmlContent), &b); err != nil {
+		t.Fatal("Failed writing Atom feed: ", err)
+	}
+	var af struct {
+		Entries []struct {
+			Lang string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(b.Bytes(), &af); err != nil {
+		t.Fatal("Failed unmarshaling Atom feed: ", err)
+	}
+	var got []string
+	for _, e := range af.Entries {
+		got = append(got, e.Lang)
+	}
+	if diff := cmp.Diff([]string{"en", "und", "en"}, got); diff != "" {
+		t.Error("Bad Atom languages:\n" + diff)
+	}
+
+	b.Reset()
+	if err := feeds.WriteXML(newRSSFeed(feed, tweets, htmlContent), &b); err != nil {
+		t.Fatal("Failed writing RSS feed: ", err)
+	}
+	var rf struct {
+		Language string `xml:"channel>language"`
+	}
+	if err := xml.Unmarshal(b.Bytes(), &rf); err != nil {
+		t.Fatal("Failed unmarshaling RSS feed: ", err)
+	}
+	if rf.Language != "en" {
+		t.Errorf("RSS language is %q; want %q", rf.Language, "en")
+	}
+	mixed := append(tweets, tweet{Href: "https://twitter.com/user/status/0", Text: "d", Lang: "es"})
+	if got := newRSSFeed(testFeed(mixed), mixed, htmlContent).Channel.Language; got != "" {
+		t.Errorf("RSS language for mixed tweets is %q; want empty", got)
+	}
+
+	b.Reset()
+	if err := json.NewEncoder(&b).Encode(newJSONFeed(feed, tweets, htmlContent)); err != nil {
+		t.Fatal("Failed writing JSON feed: ", err)
+	}
+	got = nil
+	for _, it := range readJSONFeed(t, b.Bytes()).Items {
+		got = append(got, it.Language)
+	}
+	if diff := cmp.Diff([]string{"en", "und", "en"}, got); diff != "" {
+		t.Error("Bad JSON languages:\n" + diff)
+	}
+}
+
+func TestFeedTextContent(t *testing.T) {
+	tweets := []tweet{{Href: "https://twitter.com/user/status/1", Text: "a"}}
+	feed := testFeed(tweets)
+	feed.Items[0].Content = "Some *text*"
+
+	af := newAtomFeed(feed, tweets, markdownContent)
+	if c := af.Entries[0].Content; c == nil || c.Type != "text" || c.Content != "Some *text*" {
+		t.Errorf("Atom content is %+v; want text", c)
+	}
+	rf := newRSSFeed(feed, tweets, markdownContent)
+	if it := rf.Channel.Items[0]; it.Content != nil || it.Description != "Some *text*" {
+		t.Errorf("RSS item has content %+v and description %q; want just description", it.Content, it.Description)
+	}
+	jf := newJSONFeed(feed, tweets, markdownContent)
+	if it := jf.Items[0]; it.ContentHTML != "" || it.ContentText != "Some *text*" {
+		t.Errorf("JSON item has content_html %q and content_text %q; want just text", it.ContentHTML, it.ContentText)
+	}
+}
+
+func TestFeedLinkCard(t *testing.T) {
+	card := &linkCard{URL: "https://example.org/", Title: "Title", Image: "https://pbs.twimg.com/card_img/1/a", Layout: largeCard}
+	tweets := []tweet{
+		{Href: "https://twitter.com/user/status/2", Text: "a", Card: card},
+		{Href: "https://twitter.com/user/status/1", Text: "b"},
+	}
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(newJSONFeed(testFeed(tweets), tweets, htmlContent)); err != nil {
+		t.Fatal("Failed writing JSON feed: ", err)
+	}
+	jf := readJSONFeed(t, b.Bytes())
+	if len(jf.Items) != 2 {
+		t.Fatalf("Got %d item(s); want 2", len(jf.Items))
+	}
+	if jf.Items[0].Image != card.Image {
+		t.Errorf("First item has image %q; want %q", jf.Items[0].Image, card.Image)
+	}
+	if diff := cmp.Diff(card, jf.Items[0].LinkCard); diff != "" {
+		t.Error("Bad first item link card:\n" + diff)
+	}
+	if jf.Items[1].Image != "" || jf.Items[1].LinkCard != nil {
+		t.Errorf("Second item has image %q and card %+v; want neither", jf.Items[1].Image, jf.Items[1].LinkCard)
+	}
+}
+
+func TestWriteFeedLimits(t *testing.T) {
+	now := time.Now()
+	var tweets []tweet
+	for i := 5; i > 0; i-- {
+		tw := testTweet("user", int64(i))
+		tw.Time = now.Add(-time.Duration(5-i) * 24 * time.Hour)
+		tweets = append(tweets, tw)
+	}
+	for _, tc := range []struct {
+		maxItems int
+		maxAge   time.Duration
+		want     []string
+	}{
+		{0, 0, []string{"5", "4", "3", "2", "1"}},
+		{2, 0, []string{"5", "4"}},
+		{0, 36 * time.Hour, []string{"5", "4"}},
+		{1, 36 * time.Hour, []string{"5"}},
+	} {
+		var b bytes.Buffer
+		opts := feedOptions{pinned: includePinned, contentType: htmlContent, maxItems: tc.maxItems, maxAge: tc.maxAge}
+		if err := writeFeed(&b, jsonFormat, []profile{{User: "user"}}, tweets, opts); err != nil {
+			t.Fatal("writeFeed failed: ", err)
+		}
+		var got []string
+		for _, it := range readJSONFeed(t, b.Bytes()).Items {
+			got = append(got, it.ID)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("maxItems %d and maxAge %v produced bad items:\n%s", tc.maxItems, tc.maxAge, diff)
+		}
+	}
+}
+
+func TestWriteFeedDescription(t *testing.T) {
+	tw := testTweet("user", 1)
+	tw.Text = "First line Second line link"
+	tw.Content = `First line<br>Second line <a href="https://example.org/">link</a>`
+	var b bytes.Buffer
+	opts := feedOptions{pinned: includePinned, contentType: htmlContent}
+	if err := writeFeed(&b, rssFormat, []profile{{User: "user"}}, []tweet{tw}, opts); err != nil {
+		t.Fatal("writeFeed failed: ", err)
+	}
+	var rf struct {
+		Descriptions []string `xml:"channel>item>description"`
+	}
+	if err := xml.Unmarshal(b.Bytes(), &rf); err != nil {
+		t.Fatal("Failed unmarshaling RSS feed: ", err)
+	}
+	want := "First line\nSecond line link (https://example.org/)"
+	if diff := cmp.Diff([]string{want}, rf.Descriptions); diff != "" {
+		t.Error("Bad RSS descriptions:\n" + diff)
+	}
+}
@@ -6,25 +6,28 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/chromedp"
 )
 
+// The JavaScript expressions used to check the page's state live in rules.go.
 const (
-	hasTweetExpr       = `!!document.querySelector('div[data-testid="tweet"]')`
-	hasTweetCheckDelay = time.Second // time to sleep between running hasTweetExpr
+	hasTweetCheckDelay = time.Second // time to sleep between running rules.hasTweetExpr
 
-	loadFailedExpr = `!!Array.from(document.querySelectorAll('div[role="button"]'))` +
-		`.find(e => e.innerText === 'Try again')`
-	protectedExpr = `!!Array.from(document.querySelectorAll('span'))` +
-		`.find(e => e.innerText === 'These Tweets are protected')`
-
-	showSensitiveExpr = `Array.from(document.querySelectorAll('article div[role=button]'))` +
-		`.filter(e => e.innerText === 'View').map(e => e.click() || true).length`
+	// When -tweet-timeout is unset, status pages of truncated tweets are given
+	// fullTextTimeoutFactor times -page-settle-delay (but at least minFullTextTimeout) to load.
+	fullTextTimeoutFactor = 5
+	minFullTextTimeout    = 10 * time.Second
 )
 
 type fetchOptions struct {
@@ -34,6 +37,8 @@ type fetchOptions struct {
 	pageSettleDelay    time.Duration
 	showSensitive      bool
 	showSensitiveDelay time.Duration
+	captureVideos      bool
+	expandTweets       bool
 	logDebug           bool
 }
 
@@ -63,6 +68,11 @@ func fetchTimeline(ctx context.Context, user string, opts fetchOptions) (string,
 	ctx, cancel = chromedp.NewContext(ctx, copts...)
 	defer cancel()
 
+	var vc *videoCapture
+	if opts.captureVideos {
+		vc = newVideoCapture(ctx)
+	}
+
 	debug("Loading page")
 	if err := chromedp.Run(ctx,
 		chromedp.EmulateViewport(int64(opts.width), int64(opts.height)),
@@ -81,7 +91,7 @@ func fetchTimeline(ctx context.Context, user string, opts fetchOptions) (string,
 		// The tctx.Err checks here are ugly, but we want to avoid returning other
 		// misleading errors when the core problem was the deadline being reached.
 		var exists bool
-		if err := chromedp.Run(tctx, chromedp.Evaluate(hasTweetExpr, &exists)); err != nil && tctx.Err() == nil {
+		if err := chromedp.Run(tctx, chromedp.Evaluate(rules.hasTweetExpr(), &exists)); err != nil && tctx.Err() == nil {
 			return "", fmt.Errorf("failed checking for tweets: %v", err)
 		} else if exists {
 			debug("Found tweets")
@@ -90,7 +100,7 @@ func fetchTimeline(ctx context.Context, user string, opts fetchOptions) (string,
 
 		if tctx.Err() == nil {
 			var failed bool
-			if err := chromedp.Run(tctx, chromedp.Evaluate(loadFailedExpr, &failed)); err != nil && tctx.Err() == nil {
+			if err := chromedp.Run(tctx, chromedp.Evaluate(rules.LoadFailedExpr, &failed)); err != nil && tctx.Err() == nil {
 				return "", fmt.Errorf("failed checking if load failed: %v", err)
 			} else if failed {
 				return "", errors.New("didn't receive tweets (rate-limited?)")
@@ -99,7 +109,7 @@ func fetchTimeline(ctx context.Context, user string, opts fetchOptions) (string,
 
 		if tctx.Err() == nil {
 			var protected bool
-			if err := chromedp.Run(tctx, chromedp.Evaluate(protectedExpr, &protected)); err != nil && tctx.Err() == nil {
+			if err := chromedp.Run(tctx, chromedp.Evaluate(rules.ProtectedExpr, &protected)); err != nil && tctx.Err() == nil {
 				return "", fmt.Errorf("failed checking if tweets are protected: %v", err)
 			} else if protected {
 				return "", errTweetsProtected
@@ -123,7 +133,7 @@ func fetchTimeline(ctx context.Context, user string, opts fetchOptions) (string,
 	if opts.showSensitive {
 		debug("Showing sensitive content")
 		var cnt int
-		if err := chromedp.Run(ctx, chromedp.Evaluate(showSensitiveExpr, &cnt)); err != nil {
+		if err := chromedp.Run(ctx, chromedp.Evaluate(rules.ShowSensitiveExpr, &cnt)); err != nil {
 			return "", fmt.Errorf("failed showing sensitive content: %v", err)
 		}
 		if cnt > 0 {
@@ -135,8 +145,252 @@ func fetchTimeline(ctx context.Context, user string, opts fetchOptions) (string,
 		}
 	}
 
+	if opts.expandTweets {
+		if err := expandTweets(ctx, opts); err != nil {
+			return "", fmt.Errorf("failed expanding truncated tweets: %v", err)
+		}
+	}
+
+	if vc != nil {
+		if err := vc.save(ctx); err != nil {
+			return "", fmt.Errorf("failed saving videos: %v", err)
+		}
+	}
+
 	// Return the rendered DOM.
 	var data string
 	err := chromedp.Run(ctx, chromedp.Evaluate(`document.documentElement.outerHTML`, &data))
 	return data, err
 }
+
+// expandTweets expands long tweets in the timeline that were truncated with "Show more".
+// Inline controls are activated directly. Tweets whose controls instead link to their
+// status pages are loaded in new tabs and their full text is copied into the timeline.
+// Failures to load individual tweets are logged but not returned, since parseTweet
+// records which tweets are still truncated.
+func expandTweets(ctx context.Context, opts fetchOptions) error {
+	var cnt int
+	if err := chromedp.Run(ctx, chromedp.Evaluate(rules.ShowMoreExpr, &cnt)); err != nil {
+		return err
+	}
+	if cnt > 0 {
+		debugf("Expanded %d truncated tweet(s) inline", cnt)
+		if dl, ok := ctx.Deadline(); !ok || time.Now().Add(opts.pageSettleDelay).Before(dl) {
+			time.Sleep(opts.pageSettleDelay)
+		}
+	}
+
+	var hrefs []string
+	if err := chromedp.Run(ctx, chromedp.Evaluate(rules.TruncatedTweetsExpr, &hrefs)); err != nil {
+		return err
+	}
+	for _, href := range hrefs {
+		debug("Loading truncated tweet ", href)
+		text, err := fetchFullText(ctx, href, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("Failed loading full text of %v: %v", href, err)
+			continue
+		}
+		b, err := json.Marshal(href)
+		if err != nil {
+			return err
+		}
+		t, err := json.Marshal(text)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		expr := fmt.Sprintf("(%s)(%s, %s)", rules.ReplaceTextFunc, b, t)
+		if err := chromedp.Run(ctx, chromedp.Evaluate(expr, &ok)); err != nil {
+			return err
+		} else if !ok {
+			log.Printf("Failed replacing truncated text of %v", href)
+		}
+	}
+	return nil
+}
+
+// fetchFullText loads the status page at href in a new tab and returns the HTML of the tweet's text.
+// If opts.tweetTimeout is unset, a deadline based on opts.pageSettleDelay is used instead so that
+// status pages that never display the tweet (e.g. because it was deleted) don't block forever.
+func fetchFullText(ctx context.Context, href string, opts fetchOptions) (string, error) {
+	ctx, cancel := chromedp.NewContext(ctx)
+	defer cancel()
+	timeout := opts.tweetTimeout
+	if timeout <= 0 {
+		if timeout = fullTextTimeoutFactor * opts.pageSettleDelay; timeout < minFullTextTimeout {
+			timeout = minFullTextTimeout
+		}
+	}
+	ctx, tcancel := context.WithTimeout(ctx, timeout)
+	defer tcancel()
+
+	if err := chromedp.Run(ctx,
+		chromedp.EmulateViewport(int64(opts.width), int64(opts.height)),
+		chromedp.Navigate(href)); err != nil {
+		return "", err
+	}
+	for {
+		// As in fetchTimeline, avoid returning misleading errors after the deadline is reached.
+		var text string
+		if err := chromedp.Run(ctx, chromedp.Evaluate(rules.FullTextExpr, &text)); err != nil && ctx.Err() == nil {
+			return "", err
+		} else if text != "" {
+			return text, nil
+		}
+
+		if ctx.Err() == nil {
+			var failed bool
+			if err := chromedp.Run(ctx, chromedp.Evaluate(rules.LoadFailedExpr, &failed)); err != nil && ctx.Err() == nil {
+				return "", fmt.Errorf("failed checking if load failed: %v", err)
+			} else if failed {
+				return "", errors.New("didn't receive tweet (rate-limited?)")
+			}
+		}
+
+		if ctx.Err() == nil {
+			var protected bool
+			if err := chromedp.Run(ctx, chromedp.Evaluate(rules.ProtectedExpr, &protected)); err != nil && ctx.Err() == nil {
+				return "", fmt.Errorf("failed checking if tweet is protected: %v", err)
+			} else if protected {
+				return "", errTweetsProtected
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(hasTweetCheckDelay):
+		}
+	}
+}
+
+// videoCapture watches network traffic to find real URLs for videos.
+// Twitter's player uses blob: URLs that are useless outside of the page,
+// but the API responses that describe tweets list the videos' MP4 and HLS variants.
+type videoCapture struct {
+	mu      sync.Mutex
+	videos  map[string][]videoVariant // keyed by videoKey
+	apiReqs map[network.RequestID]struct{}
+	pending sync.WaitGroup // response bodies being fetched
+	saving  bool           // save has been called, so pending must not be incremented
+}
+
+// newVideoCapture returns a new videoCapture listening to ctx's target.
+// It must be called before the page is loaded.
+func newVideoCapture(ctx context.Context) *videoCapture {
+	vc := &videoCapture{
+		videos:  make(map[string][]videoVariant),
+		apiReqs: make(map[network.RequestID]struct{}),
+	}
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *network.EventResponseReceived:
+			vc.handleResponse(ev)
+		case *network.EventLoadingFinished:
+			vc.mu.Lock()
+			_, ok := vc.apiReqs[ev.RequestID]
+			delete(vc.apiReqs, ev.RequestID)
+			fetch := ok && !vc.saving
+			if fetch {
+				vc.pending.Add(1)
+			}
+			vc.mu.Unlock()
+			if fetch {
+				// Listeners mustn't block, so fetch the body asynchronously.
+				go func() {
+					defer vc.pending.Done()
+					vc.readBody(ctx, ev.RequestID)
+				}()
+			}
+		}
+	})
+	return vc
+}
+
+// handleResponse records video URLs and notes API responses that may describe videos.
+func (vc *videoCapture) handleResponse(ev *network.EventResponseReceived) {
+	u := ev.Response.URL
+	pu, err := url.Parse(u)
+	if err != nil {
+		return
+	}
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	switch {
+	case pu.Host == "video.twimg.com":
+		if key, ct := videoKey(u), videoContentType(u); key != "" && ct != "" {
+			// Drop the query string, which just contains parameters like "tag=12".
+			pu.RawQuery = ""
+			addVideoVariant(vc.videos, key, videoVariant{URL: pu.String(), ContentType: ct})
+		}
+	case (pu.Host == "api.twitter.com" || strings.HasPrefix(pu.Path, "/i/api/")) &&
+		strings.Contains(ev.Response.MimeType, "json"):
+		vc.apiReqs[ev.RequestID] = struct{}{}
+	}
+}
+
+// readBody fetches the body of the API response with the supplied ID and records its videos.
+func (vc *videoCapture) readBody(ctx context.Context, id network.RequestID) {
+	body, err := network.GetResponseBody(id).Do(
+		cdp.WithExecutor(ctx, chromedp.FromContext(ctx).Target))
+	if err != nil {
+		debugf("Failed getting response body: %v", err)
+		return
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return
+	}
+	vc.mu.Lock()
+	findVideoVariants(v, vc.videos)
+	vc.mu.Unlock()
+}
+
+// save waits for pending response bodies and then adds the captured videos to the page's DOM
+// in a <script> element that is read by readVideoVariants.
+func (vc *videoCapture) save(ctx context.Context) error {
+	vc.mu.Lock()
+	vc.saving = true
+	vc.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		vc.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	vc.mu.Lock()
+	b, err := json.Marshal(vc.videos)
+	n := len(vc.videos)
+	vc.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	debugf("Captured %d video(s)", n)
+
+	// Marshal the JSON again to get a JavaScript string literal.
+	lit, err := json.Marshal(string(b))
+	if err != nil {
+		return err
+	}
+	expr := fmt.Sprintf(`(() => {
+  const s = document.createElement('script');
+  s.type = 'application/json';
+  s.id = '%s';
+  s.textContent = %s;
+  document.body.appendChild(s);
+  return true;
+})()`, videoSourcesID, lit)
+	var res bool
+	return chromedp.Run(ctx, chromedp.Evaluate(expr, &res))
+}
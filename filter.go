@@ -0,0 +1,242 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// filterRule matches tweets based on their content and metadata.
+//
+// Rules are written as comma-separated conditions, all of which must match:
+//
+//	text:<regexp>    text matches the regular expression (must be last, since it may contain commas)
+//	hashtag:<tag>    has the hashtag (without '#', case-insensitive)
+//	author:<user>    was written by the user (without '@', case-insensitive)
+//	lang:<lang>      is in the language, e.g. "en" (matching "en-GB" as well)
+//	media            contains images or videos
+//	link             contains links to other sites or a link card
+//	retweet          was retweeted by the timeline's user
+//	quote            quotes another tweet
+//	<count><op><n>   count ("replies", "retweets", or "likes") compared to n
+//	                 using op (">=", ">", "<=", or "<")
+//
+// Conditions can be negated by prefixing them with '!', e.g. "!media".
+type filterRule struct {
+	src   string
+	conds []filterCond
+}
+
+// filterCond is a single condition within a filterRule.
+type filterCond struct {
+	neg   bool
+	match func(t *tweet) bool
+}
+
+var countCondRegexp = regexp.MustCompile(`^(replies|retweets|likes)(>=|>|<=|<)(\d+)$`)
+
+// parseFilterRule parses a rule in the format described by filterRule.
+func parseFilterRule(s string) (filterRule, error) {
+	rule := filterRule{src: s}
+	for s != "" {
+		var term string
+		if strings.HasPrefix(strings.TrimPrefix(strings.TrimSpace(s), "!"), "text:") {
+			term, s = strings.TrimSpace(s), ""
+		} else if i := strings.IndexByte(s, ','); i >= 0 {
+			term, s = strings.TrimSpace(s[:i]), s[i+1:]
+		} else {
+			term, s = strings.TrimSpace(s), ""
+		}
+		if term == "" {
+			continue
+		}
+		cond, err := parseFilterCond(term)
+		if err != nil {
+			return rule, fmt.Errorf("bad condition %q: %v", term, err)
+		}
+		rule.conds = append(rule.conds, cond)
+	}
+	if len(rule.conds) == 0 {
+		return rule, fmt.Errorf("no conditions in %q", rule.src)
+	}
+	return rule, nil
+}
+
+// parseFilterCond parses a single condition from a filterRule.
+func parseFilterCond(term string) (filterCond, error) {
+	var cond filterCond
+	if strings.HasPrefix(term, "!") {
+		cond.neg = true
+		term = term[1:]
+	}
+
+	if ms := countCondRegexp.FindStringSubmatch(term); ms != nil {
+		n, err := strconv.ParseInt(ms[3], 10, 64)
+		if err != nil {
+			return cond, err
+		}
+		get := map[string]func(t *tweet) int64{
+			"replies":  func(t *tweet) int64 { return t.Replies },
+			"retweets": func(t *tweet) int64 { return t.Retweets },
+			"likes":    func(t *tweet) int64 { return t.Likes },
+		}[ms[1]]
+		switch ms[2] {
+		case ">=":
+			cond.match = func(t *tweet) bool { return get(t) >= n }
+		case ">":
+			cond.match = func(t *tweet) bool { return get(t) > n }
+		case "<=":
+			cond.match = func(t *tweet) bool { return get(t) <= n }
+		case "<":
+			cond.match = func(t *tweet) bool { return get(t) < n }
+		}
+		return cond, nil
+	}
+
+	key, val := term, ""
+	if i := strings.IndexByte(term, ':'); i >= 0 {
+		key, val = term[:i], term[i+1:]
+	}
+	if f, ok := boolConds[key]; ok {
+		if val != "" {
+			return cond, fmt.Errorf("unexpected value")
+		}
+		cond.match = f
+		return cond, nil
+	}
+	if val == "" {
+		return cond, fmt.Errorf("unknown condition or missing value")
+	}
+	switch key {
+	case "text":
+		re, err := regexp.Compile(val)
+		if err != nil {
+			return cond, err
+		}
+		cond.match = func(t *tweet) bool { return re.MatchString(t.Text) }
+	case "hashtag":
+		tag := strings.TrimPrefix(val, "#")
+		cond.match = func(t *tweet) bool {
+			for _, h := range t.Hashtags {
+				if strings.EqualFold(h, tag) {
+					return true
+				}
+			}
+			return false
+		}
+	case "author":
+		user := bareUser(val)
+		cond.match = func(t *tweet) bool { return strings.EqualFold(t.User, user) }
+	case "lang":
+		cond.match = func(t *tweet) bool {
+			return strings.EqualFold(t.Lang, val) || strings.EqualFold(primaryLang(t.Lang), val)
+		}
+	default:
+		return cond, fmt.Errorf("unknown condition")
+	}
+	return cond, nil
+}
+
+// boolConds contains conditions that don't take values.
+var boolConds = map[string]func(t *tweet) bool{
+	"media":   func(t *tweet) bool { return t.Media },
+	"link":    func(t *tweet) bool { return len(t.URLs) > 0 || t.Card != nil },
+	"retweet": func(t *tweet) bool { return t.Retweet },
+	"quote":   func(t *tweet) bool { return t.Quote },
+}
+
+// match returns true if all of r's conditions match t.
+func (r *filterRule) match(t *tweet) bool {
+	for _, c := range r.conds {
+		if c.match(t) == c.neg {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the rule as originally written.
+func (r filterRule) String() string { return r.src }
+
+// filterSet contains rules describing which tweets should be included in feeds.
+type filterSet struct {
+	Include []filterRule // if non-empty, tweets must match at least one of these
+	Exclude []filterRule // tweets matching any of these are dropped
+}
+
+// addRules parses and appends the supplied include and exclude rules to fs.
+func (fs *filterSet) addRules(include, exclude []string) error {
+	for _, s := range include {
+		r, err := parseFilterRule(s)
+		if err != nil {
+			return err
+		}
+		fs.Include = append(fs.Include, r)
+	}
+	for _, s := range exclude {
+		r, err := parseFilterRule(s)
+		if err != nil {
+			return err
+		}
+		fs.Exclude = append(fs.Exclude, r)
+	}
+	return nil
+}
+
+// loadFilters reads rules from p, a JSON file containing "include" and "exclude"
+// lists of rules in the format described by filterRule.
+func loadFilters(p string) (filterSet, error) {
+	var fs filterSet
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return fs, err
+	}
+	var cfg struct {
+		Include []string `json:"include"`
+		Exclude []string `json:"exclude"`
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return fs, err
+	}
+	err = fs.addRules(cfg.Include, cfg.Exclude)
+	return fs, err
+}
+
+// check returns an empty string if t should be included given fs's rules,
+// or a description of why it should be dropped otherwise.
+func (fs *filterSet) check(t *tweet) string {
+	for _, r := range fs.Exclude {
+		if r.match(t) {
+			return fmt.Sprintf("matched exclude rule %q", r.src)
+		}
+	}
+	if len(fs.Include) == 0 {
+		return ""
+	}
+	for _, r := range fs.Include {
+		if r.match(t) {
+			return ""
+		}
+	}
+	return "didn't match any include rules"
+}
+
+// filterRulesFlag implements flag.Value to collect rules from a repeated flag.
+type filterRulesFlag []string
+
+func (f *filterRulesFlag) String() string { return strings.Join(*f, " ") }
+
+func (f *filterRulesFlag) Set(s string) error {
+	if _, err := parseFilterRule(s); err != nil {
+		return err
+	}
+	*f = append(*f, s)
+	return nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilterRuleMatch(t *testing.T) {
+	tw := tweet{
+		User:     "User",
+		Text:     "Big sale, today only",
+		Lang:     "en-GB",
+		Hashtags: []string{"Sale"},
+		URLs:     []string{"https://example.org/"},
+		Media:    true,
+		Retweet:  true,
+		Replies:  5,
+		Retweets: 10,
+		Likes:    100,
+	}
+	for _, tc := range []struct {
+		rule string
+		want bool
+	}{
+		{"text:(?i)big sale", true},
+		{"text:sale, today", true}, // text consumes the rest of the rule
+		{"text:^sale", false},
+		{"!text:^sale", true},
+		{"hashtag:sale", true},
+		{"hashtag:#SALE", true},
+		{"hashtag:other", false},
+		{"author:@user", true},
+		{"author:other", false},
+		{"lang:en", true},
+		{"lang:en-gb", true},
+		{"lang:es", false},
+		{"media", true},
+		{"!media", false},
+		{"link", true},
+		{"retweet", true},
+		{"quote", false},
+		{"!quote", true},
+		{"likes>=100", true},
+		{"likes>100", false},
+		{"retweets<10", false},
+		{"retweets<=10", true},
+		{"replies>4", true},
+		{"media, likes>=50, !quote", true},
+		{"media,likes>=500", false},
+		{"author:user, text:only$", true},
+	} {
+		r, err := parseFilterRule(tc.rule)
+		if err != nil {
+			t.Errorf("parseFilterRule(%q) failed: %v", tc.rule, err)
+			continue
+		}
+		if got := r.match(&tw); got != tc.want {
+			t.Errorf("Rule %q matched %v; want %v", tc.rule, got, tc.want)
+		}
+	}
+}
+
+func TestParseFilterRuleInvalid(t *testing.T) {
+	for _, rule := range []string{
+		"",
+		" , ",
+		"bogus",
+		"media:yes",
+		"text:(",
+		"hashtag:",
+		"likes=5",
+		"author",
+	} {
+		if _, err := parseFilterRule(rule); err == nil {
+			t.Errorf("parseFilterRule(%q) unexpectedly succeeded", rule)
+		}
+	}
+}
+
+func TestFilterSetCheck(t *testing.T) {
+	var fs filterSet
+	if err := fs.addRules([]string{"media", "likes>=100"}, []string{"retweet", "hashtag:ad"}); err != nil {
+		t.Fatal("addRules failed: ", err)
+	}
+	for _, tc := range []struct {
+		tw   tweet
+		want string
+	}{
+		{tweet{Media: true}, ""},
+		{tweet{Likes: 100}, ""},
+		{tweet{Likes: 10}, "didn't match any include rules"},
+		{tweet{Media: true, Retweet: true}, `matched exclude rule "retweet"`},
+		{tweet{Likes: 200, Hashtags: []string{"ad"}}, `matched exclude rule "hashtag:ad"`},
+	} {
+		if got := fs.check(&tc.tw); got != tc.want {
+			t.Errorf("check(%+v) = %q; want %q", tc.tw, got, tc.want)
+		}
+	}
+
+	// Everything should be included by an empty set.
+	var empty filterSet
+	if got := empty.check(&tweet{}); got != "" {
+		t.Errorf("Empty set returned %q", got)
+	}
+}
+
+func TestLoadFilters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "twittuh.filter_test.")
+	if err != nil {
+		t.Fatal("Failed creating temp dir: ", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "filters.json")
+	if err := ioutil.WriteFile(p, []byte(`{"include": ["media"], "exclude": ["text:a,b", "quote"]}`), 0644); err != nil {
+		t.Fatal("Failed writing filters: ", err)
+	}
+	fs, err := loadFilters(p)
+	if err != nil {
+		t.Fatal("loadFilters failed: ", err)
+	}
+	if len(fs.Include) != 1 || len(fs.Exclude) != 2 {
+		t.Errorf("loadFilters returned %v include and %v exclude rule(s); want 1 and 2",
+			len(fs.Include), len(fs.Exclude))
+	}
+
+	if err := ioutil.WriteFile(p, []byte(`{"exclude": ["bogus"]}`), 0644); err != nil {
+		t.Fatal("Failed writing filters: ", err)
+	}
+	if _, err := loadFilters(p); err == nil {
+		t.Error("loadFilters unexpectedly succeeded for bad rule")
+	}
+}
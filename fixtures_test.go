@@ -0,0 +1,66 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTime is the time of testTweet's tweet with ID 0.
+var testTime = time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// testTweet returns a tweet from user with the supplied ID, posted id minutes after testTime.
+// Tests can override fields as needed.
+func testTweet(user string, id int64) tweet {
+	return tweet{
+		ID:      id,
+		Href:    fmt.Sprintf("https://twitter.com/%s/status/%d", user, id),
+		User:    user,
+		Name:    strings.ToUpper(user),
+		Time:    testTime.Add(time.Duration(id) * time.Minute),
+		Content: fmt.Sprintf("Tweet <b>%d</b>", id),
+		Text:    fmt.Sprintf("Tweet %d", id),
+		Title:   fmt.Sprintf("Tweet %d", id),
+	}
+}
+
+// tweetIDs returns the IDs of tweets.
+func tweetIDs(tweets []tweet) []int64 {
+	var ids []int64
+	for _, t := range tweets {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
+
+// testJSONFeed contains fields read from a JSON feed by readJSONFeed.
+type testJSONFeed struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	HomePageURL string `json:"home_page_url"`
+	Items       []struct {
+		ID       string    `json:"id"`
+		Title    string    `json:"title"`
+		Language string    `json:"language"`
+		Image    string    `json:"image"`
+		LinkCard *linkCard `json:"_link_card"`
+		Author   struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"items"`
+}
+
+// readJSONFeed unmarshals the JSON feed in b.
+func readJSONFeed(t *testing.T, b []byte) testJSONFeed {
+	var jf testJSONFeed
+	if err := json.Unmarshal(b, &jf); err != nil {
+		t.Fatal("Failed unmarshaling JSON feed: ", err)
+	}
+	return jf
+}
@@ -3,6 +3,7 @@ module github.com/derat/twittuh
 go 1.14
 
 require (
+	github.com/chromedp/cdproto v0.0.0-20210323015217-0942afbea50e
 	github.com/chromedp/chromedp v0.6.10
 	github.com/derat/htmlpretty v0.0.0-20200529155732-22cbe49e3770
 	github.com/google/go-cmp v0.5.0
@@ -122,6 +122,18 @@ func getAttr(n *html.Node, attr string) string {
 	return ""
 }
 
+// setAttr sets the first occurrence of the named attribute in n to val.
+// The attribute is added if it isn't already present.
+func setAttr(n *html.Node, attr, val string) {
+	for i, a := range n.Attr {
+		if a.Key == attr {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: attr, Val: val})
+}
+
 // deleteAttr recursively deletes attr from n and its descendents.
 func deleteAttr(n *html.Node, attr string) {
 	i := 0
@@ -140,6 +152,13 @@ func deleteAttr(n *html.Node, attr string) {
 
 // getText concatenates all text content in and under n.
 func getText(n *html.Node, addSpaces bool) string {
+	return getTextFunc(n, addSpaces, nil)
+}
+
+// getTextFunc is like getText, but if elemText is non-nil, it is called for each element
+// under n. If it returns a non-empty string, the string is used in place of the element's
+// text content.
+func getTextFunc(n *html.Node, addSpaces bool, elemText func(*html.Node) string) string {
 	if n == nil {
 		return ""
 	}
@@ -151,7 +170,13 @@ func getText(n *html.Node, addSpaces bool) string {
 		}
 	}
 	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		s := getText(c, addSpaces)
+		var s string
+		if c.Type == html.ElementNode && elemText != nil {
+			s = elemText(c)
+		}
+		if s == "" {
+			s = getTextFunc(c, addSpaces, elemText)
+		}
 		if addSpaces {
 			if s = strings.TrimSpace(s); s != "" {
 				if text != "" {
@@ -0,0 +1,145 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Labels that Twitter attaches to tweets. These are also used as feed item categories.
+const (
+	sensitiveLabel   = "sensitive"
+	manipulatedLabel = "manipulated-media"
+	misleadingLabel  = "misleading"
+)
+
+// labelNames contains human-readable names for labels.
+var labelNames = map[string]string{
+	sensitiveLabel:   "Sensitive content",
+	manipulatedLabel: "Manipulated media",
+	misleadingLabel:  "Misleading",
+}
+
+// labelRule describes a label that Twitter displays within tweets.
+type labelRule struct {
+	Label string `json:"label"` // label name, e.g. "sensitive"
+	Text  string `json:"text"`  // prefix of the text that Twitter displays
+}
+
+// sensitiveAttr is added to <article> elements by rules.ShowSensitiveExpr when it reveals
+// sensitive content, since the content warning is removed from the DOM at the same time.
+const sensitiveAttr = "data-twittuh-sensitive"
+
+// noteHeadings contains headings that precede the text of Community Notes.
+var noteHeadings = []string{
+	"Readers added context they thought people might want to know",
+	"Readers added context",
+}
+
+// extractLabels sets tw's Note and Labels from n, a tweet div, and removes the
+// corresponding elements from body, the tweet's body. Elements are removed so that they
+// aren't mistaken for the tweet's text or embed; labelBlock renders them instead.
+func extractLabels(n, body *html.Node, tw *tweet) {
+	if art := tweetArticle(n); art != nil && matchFunc("article", sensitiveAttr)(art) {
+		tw.Labels = appendUnique(tw.Labels, sensitiveLabel)
+	}
+
+	if note := findFirstNode(body, rules.CommunityNote.match); note != nil {
+		text := strings.TrimSpace(getText(note, true))
+		for _, h := range noteHeadings {
+			if strings.HasPrefix(text, h) {
+				text = strings.TrimSpace(text[len(h):])
+				break
+			}
+		}
+		tw.Note = text
+		removeWithEmptyAncestors(note, body)
+	}
+
+	for _, lr := range rules.ContentLabels {
+		if lr.Text == "" {
+			continue
+		}
+		for _, tn := range findNodes(body, func(n *html.Node) bool {
+			return isText(n) && strings.HasPrefix(strings.TrimSpace(n.Data), lr.Text)
+		}) {
+			if tn.Parent == nil || inTweetText(tn, body) || inEmbed(tn, body) {
+				continue
+			}
+			// Remove the largest ancestor that just contains the label, e.g. including the
+			// "View" button that reveals sensitive media but not the media itself.
+			el := tn
+			for p := tn.Parent; p != nil && p != body &&
+				strings.HasPrefix(strings.TrimSpace(getText(p, true)), lr.Text) &&
+				findFirstNode(p, func(n *html.Node) bool {
+					return isElement(n, "img") || isElement(n, "video")
+				}) == nil; p = p.Parent {
+				el = p
+			}
+			removeWithEmptyAncestors(el, body)
+			tw.Labels = appendUnique(tw.Labels, lr.Label)
+		}
+	}
+}
+
+// inTweetText returns true if n is within tweet text (possibly a quoted tweet's) under body.
+func inTweetText(n, body *html.Node) bool {
+	for p := n.Parent; p != nil && p != body; p = p.Parent {
+		if matchFunc("div", "lang")(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// inEmbed returns true if n is within a link card or quoted tweet under body.
+// Text there (e.g. a card titled "Get the facts about ...") doesn't label the tweet.
+func inEmbed(n, body *html.Node) bool {
+	for p := n.Parent; p != nil && p != body; p = p.Parent {
+		for _, s := range rules.LinkCards {
+			if s.match(p) {
+				return true
+			}
+		}
+		if quoteTweetHeader(p) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// labelBlock returns a <p> element describing t's labels and Community Note,
+// or nil if it has neither.
+func (t *tweet) labelBlock() *html.Node {
+	if len(t.Labels) == 0 && t.Note == "" {
+		return nil
+	}
+	p := &html.Node{Type: html.ElementNode, DataAtom: atom.P, Data: "p"}
+	addLine := func(heading, text string) {
+		if p.FirstChild != nil {
+			p.AppendChild(&html.Node{Type: html.ElementNode, DataAtom: atom.Br, Data: "br"})
+		}
+		b := &html.Node{Type: html.ElementNode, DataAtom: atom.B, Data: "b"}
+		b.AppendChild(&html.Node{Type: html.TextNode, Data: heading + ":"})
+		p.AppendChild(b)
+		p.AppendChild(&html.Node{Type: html.TextNode, Data: " " + text})
+	}
+	if len(t.Labels) > 0 {
+		names := make([]string, len(t.Labels))
+		for i, l := range t.Labels {
+			if names[i] = labelNames[l]; names[i] == "" {
+				names[i] = l
+			}
+		}
+		addLine("Labels", strings.Join(names, ", "))
+	}
+	if t.Note != "" {
+		addLine("Readers added context", t.Note)
+	}
+	return p
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/html"
+)
+
+func TestExtractLabels(t *testing.T) {
+	const img = `<div><img src="https://pbs.twimg.com/media/abc?format=jpg"/></div>`
+	for _, tc := range []struct {
+		desc   string
+		orig   string // HTML containing a tweet div with a body div
+		labels []string
+		note   string
+		body   string // body after extraction
+	}{
+		{
+			"none",
+			`<article><div data-testid="tweet"><div><div lang="en">Manipulated media</div>` + img + `</div></div></article>`,
+			nil, "",
+			`<div><div lang="en">Manipulated media</div>` + img + `</div>`,
+		},
+		{
+			"revealed sensitive media",
+			`<article ` + sensitiveAttr + `=""><div data-testid="tweet"><div><div lang="en">Hi</div>` + img + `</div></div></article>`,
+			[]string{sensitiveLabel}, "",
+			`<div><div lang="en">Hi</div>` + img + `</div>`,
+		},
+		{
+			"warning and label",
+			`<article><div data-testid="tweet"><div><div lang="en">Hi</div>` +
+				`<div><div><div><span>The following media includes potentially sensitive content.</span>` +
+				`<a href="/settings">Change settings</a></div><div role="button">View</div></div>` + img + `</div>` +
+				`<div><a href="https://help.twitter.com/"><svg></svg><span>Manipulated media</span></a></div>` +
+				`</div></div></article>`,
+			[]string{sensitiveLabel, manipulatedLabel}, "",
+			`<div><div lang="en">Hi</div><div>` + img + `</div></div>`,
+		},
+		{
+			"link card",
+			`<article><div data-testid="tweet"><div><div lang="en">Hi</div>` +
+				`<div><a href="https://t.co/abc"><div data-testid="card.layoutSmall.detail">` +
+				`<div><span>example.org</span></div><div><span>Get the facts about vaccines</span></div>` +
+				`<div><span>Manipulated media, explained</span></div></div></a></div></div></div></article>`,
+			nil, "",
+			`<div><div lang="en">Hi</div><div><a href="https://t.co/abc"><div data-testid="card.layoutSmall.detail">` +
+				`<div><span>example.org</span></div><div><span>Get the facts about vaccines</span></div>` +
+				`<div><span>Manipulated media, explained</span></div></div></a></div></div>`,
+		},
+		{
+			"quoted tweet",
+			`<article><div data-testid="tweet"><div><div lang="en">Hi</div>` +
+				`<div><div role="link"><div><div><span><time datetime="2021-01-01T00:00:00.000Z">Jan 1</time></span></div></div>` +
+				`<div><span>Stay informed about the storm</span></div></div></div></div></div></article>`,
+			nil, "",
+			`<div><div lang="en">Hi</div><div><div role="link"><div><div><span><time datetime="2021-01-01T00:00:00.000Z">Jan 1</time></span></div></div>` +
+				`<div><span>Stay informed about the storm</span></div></div></div></div>`,
+		},
+		{
+			"community note",
+			`<article><div data-testid="tweet"><div><div lang="en">Hi</div>` +
+				`<div><div data-testid="birdwatch-pivot"><span>Readers added context</span>` +
+				`<span>This is wrong.</span></div></div></div></div></article>`,
+			nil, "This is wrong.",
+			`<div><div lang="en">Hi</div></div>`,
+		},
+	} {
+		root, err := html.Parse(strings.NewReader(tc.orig))
+		if err != nil {
+			t.Fatalf("%s: failed parsing %q: %v", tc.desc, tc.orig, err)
+		}
+		n := findFirstNode(root, rules.Tweet.match)
+		body := n.FirstChild
+		var tw tweet
+		extractLabels(n, body, &tw)
+		if diff := cmp.Diff(tc.labels, tw.Labels); diff != "" {
+			t.Errorf("%s: bad labels:\n%s", tc.desc, diff)
+		}
+		if tw.Note != tc.note {
+			t.Errorf("%s: got note %q; want %q", tc.desc, tw.Note, tc.note)
+		}
+		var b bytes.Buffer
+		if err := html.Render(&b, body); err != nil {
+			t.Fatal("Failed rendering tree: ", err)
+		}
+		if got := b.String(); got != tc.body {
+			t.Errorf("%s: extractLabels produced %q; want %q", tc.desc, got, tc.body)
+		}
+	}
+}
+
+func TestLabelBlock(t *testing.T) {
+	for _, tc := range []struct {
+		tw   tweet
+		want string
+	}{
+		{tweet{}, ""},
+		{tweet{Labels: []string{sensitiveLabel, "other"}},
+			`<p><b>Labels:</b> Sensitive content, other</p>`},
+		{tweet{Labels: []string{misleadingLabel}, Note: "Not true & misleading."},
+			`<p><b>Labels:</b> Misleading<br/><b>Readers added context:</b> Not true &amp; misleading.</p>`},
+	} {
+		var got string
+		if n := tc.tw.labelBlock(); n != nil {
+			var b bytes.Buffer
+			if err := html.Render(&b, n); err != nil {
+				t.Fatal("Failed rendering block: ", err)
+			}
+			got = b.String()
+		}
+		if got != tc.want {
+			t.Errorf("labelBlock() for %+v = %q; want %q", tc.tw, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	shortLinkHost      = "t.co"
+	linkResolveTimeout = 10 * time.Second
+)
+
+// linkResolver looks up the destinations of t.co short links over HTTP.
+// Results are cached in memory and optionally persisted to disk.
+type linkResolver struct {
+	client    *http.Client
+	cachePath string // JSON file used to persist cache; empty if none
+
+	mu    sync.Mutex
+	cache map[string]string // short URL to destination URL
+	dirty bool              // cache modified since last save
+}
+
+// newLinkResolver returns a new linkResolver that sends requests through proxy
+// (e.g. "socks5://localhost:9050") if non-empty. If cachePath is non-empty, previously-saved
+// results are loaded from it, and save writes the cache back to it.
+func newLinkResolver(proxy, cachePath string) (*linkResolver, error) {
+	tr := &http.Transport{}
+	if proxy != "" {
+		pu, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("bad proxy %q: %v", proxy, err)
+		}
+		tr.Proxy = http.ProxyURL(pu)
+	}
+	r := &linkResolver{
+		client: &http.Client{
+			Transport: tr,
+			Timeout:   linkResolveTimeout,
+			// We only want to look at the first hop.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		cachePath: cachePath,
+		cache:     make(map[string]string),
+	}
+	if cachePath != "" {
+		b, err := ioutil.ReadFile(cachePath)
+		if err == nil {
+			if err := json.Unmarshal(b, &r.cache); err != nil {
+				return nil, fmt.Errorf("failed reading %v: %v", cachePath, err)
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// resolve returns the destination of the supplied short link.
+func (r *linkResolver) resolve(short string) (string, error) {
+	// Twitter adds junk like "?amp=1" to its short links.
+	u, err := url.Parse(short)
+	if err != nil {
+		return "", err
+	}
+	u.RawQuery = ""
+	short = u.String()
+
+	r.mu.Lock()
+	dst, ok := r.cache[short]
+	r.mu.Unlock()
+	if ok {
+		return dst, nil
+	}
+
+	debugf("Resolving %v", short)
+	resp, err := r.client.Head(short)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return "", fmt.Errorf("got status %v instead of redirect", resp.StatusCode)
+	}
+	loc, err := resp.Location()
+	if err != nil {
+		return "", err
+	}
+	dst = loc.String()
+
+	r.mu.Lock()
+	r.cache[short] = dst
+	r.dirty = true
+	r.mu.Unlock()
+	return dst, nil
+}
+
+// save atomically writes the cache to r.cachePath if it's been modified.
+func (r *linkResolver) save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cachePath == "" || !r.dirty {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(r.cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(r.cachePath), "."+filepath.Base(r.cachePath)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // silently fails if we successfully rename temp file
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), r.cachePath); err != nil {
+		return err
+	}
+	r.dirty = false
+	return nil
+}
+
+// expandLinks rewrites t.co links under root to point at their destinations.
+// The destination is taken from the title or text of a link with the same href if possible.
+// Otherwise, r is used to look it up if non-nil.
+func expandLinks(root *html.Node, r *linkResolver) {
+	var links []*html.Node
+	dests := make(map[string]string) // short URLs to destinations
+	for _, link := range findNodes(root, matchFunc("a", "href")) {
+		href := getAttr(link, "href")
+		if u, err := url.Parse(href); err != nil || u.Host != shortLinkHost {
+			continue
+		}
+		links = append(links, link)
+		// Link cards reuse the short URL from the tweet's text without displaying the destination.
+		if dst := linkDest(link); dst != "" {
+			dests[href] = dst
+		}
+	}
+
+	for _, link := range links {
+		href := getAttr(link, "href")
+		dst, ok := dests[href]
+		if !ok && r != nil {
+			var err error
+			if dst, err = r.resolve(href); err != nil {
+				debugf("Failed resolving %v: %v", href, err)
+			}
+			dests[href] = dst
+		}
+		if dst != "" {
+			setAttr(link, "href", dst)
+		}
+	}
+}
+
+// linkDest returns the destination URL that Twitter displays in link's title or text.
+// An empty string is returned if the destination isn't available.
+func linkDest(link *html.Node) string {
+	isURL := func(s string) bool {
+		return (strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")) &&
+			strings.IndexFunc(s, func(r rune) bool { return r == ' ' || r == '\n' }) == -1
+	}
+	if t := getAttr(link, "title"); isURL(t) {
+		return t
+	}
+	// The text contains the full URL (with the scheme and the elided portion
+	// hidden via aria-hidden), followed by an ellipsis if it's long.
+	if t := strings.TrimRight(getText(link, false), "…"); isURL(t) {
+		return t
+	}
+	return ""
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestExpandLinks(t *testing.T) {
+	for _, tc := range []struct {
+		orig, want string
+	}{
+		{`<a href="https://t.co/abc" title="https://example.org/a">x</a>`,
+			`<a href="https://example.org/a" title="https://example.org/a">x</a>`},
+		{`<a href="https://t.co/abc?amp=1"><span>https://</span>example.org/<span>b/c</span><span>…</span></a>`,
+			`<a href="https://example.org/b/c"><span>https://</span>example.org/<span>b/c</span><span>…</span></a>`},
+		{`<a href="https://t.co/abc">example.org</a>`, `<a href="https://t.co/abc">example.org</a>`},
+		{`<a href="https://t.co/abc">https://example.org/</a><a href="https://t.co/abc">card</a>`,
+			`<a href="https://example.org/">https://example.org/</a><a href="https://example.org/">card</a>`},
+		{`<a href="https://example.com/" title="https://example.org/">x</a>`,
+			`<a href="https://example.com/" title="https://example.org/">x</a>`},
+	} {
+		root, err := html.Parse(strings.NewReader(tc.orig))
+		if err != nil {
+			t.Fatalf("Failed parsing %q: %v", tc.orig, err)
+		}
+		body := findFirstNode(root, matchFunc("body"))
+		expandLinks(body, nil)
+
+		var b bytes.Buffer
+		for n := body.FirstChild; n != nil; n = n.NextSibling {
+			if err := html.Render(&b, n); err != nil {
+				t.Fatal("Failed rendering tree: ", err)
+			}
+		}
+		if got := b.String(); got != tc.want {
+			t.Errorf("expandLinks(%q) = %q; want %q", tc.orig, got, tc.want)
+		}
+	}
+}
+
+func TestLinkResolver(t *testing.T) {
+	const dst = "https://example.org/dest"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/short" {
+			http.Redirect(w, req, dst, http.StatusMovedPermanently)
+		} else {
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "twittuh.links_test.")
+	if err != nil {
+		t.Fatal("Failed creating temp dir: ", err)
+	}
+	defer os.RemoveAll(dir)
+	cachePath := filepath.Join(dir, "cache.json")
+
+	r, err := newLinkResolver("", cachePath)
+	if err != nil {
+		t.Fatal("Failed creating resolver: ", err)
+	}
+	if got, err := r.resolve(srv.URL + "/short?amp=1"); err != nil {
+		t.Error("resolve failed: ", err)
+	} else if got != dst {
+		t.Errorf("resolve returned %q; want %q", got, dst)
+	}
+	if got, err := r.resolve(srv.URL + "/bogus"); err == nil {
+		t.Errorf("resolve of bogus URL unexpectedly returned %q", got)
+	}
+	if err := r.save(); err != nil {
+		t.Fatal("save failed: ", err)
+	}
+
+	// After the server is gone, a new resolver should still be able to use the saved result.
+	srv.Close()
+	if r, err = newLinkResolver("", cachePath); err != nil {
+		t.Fatal("Failed creating second resolver: ", err)
+	}
+	if got, err := r.resolve(srv.URL + "/short"); err != nil {
+		t.Error("resolve from cache failed: ", err)
+	} else if got != dst {
+		t.Errorf("resolve from cache returned %q; want %q", got, dst)
+	}
+}
@@ -5,6 +5,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -15,8 +16,8 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/mail"
 	"os"
-	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -29,11 +30,40 @@ import (
 type feedFormat string
 
 const (
-	atomFormat feedFormat = "atom"
-	jsonFormat feedFormat = "json"
-	rssFormat  feedFormat = "rss"
+	atomFormat     feedFormat = "atom"
+	jsonFormat     feedFormat = "json"
+	rssFormat      feedFormat = "rss"
+	activityFormat feedFormat = "activitystreams"
+	emailFormat    feedFormat = "email" // MIME message containing a digest of new tweets
 )
 
+// pinnedMode describes how the user's pinned tweet is handled.
+type pinnedMode string
+
+const (
+	includePinned pinnedMode = "include" // always include the pinned tweet
+	skipPinned    pinnedMode = "skip"    // never include the pinned tweet
+	newPinned     pinnedMode = "new"     // include the pinned tweet if it's newer than the old feed
+)
+
+// feedOptions controls which tweets are written by writeFeed.
+type feedOptions struct {
+	replies     bool           // include the user's replies
+	threads     bool           // merge threads of self-replies into single items
+	skipUsers   []string       // users whose tweets should be skipped
+	langs       []string       // if non-empty, only include tweets in these languages
+	skipLangs   []string       // languages whose tweets should be skipped
+	pinned      pinnedMode     // how the pinned tweet should be handled
+	oldLatestID int64          // latest ID from the previous version of the feed (used by newPinned)
+	contentType contentType    // format for item content
+	maxItems    int            // if positive, maximum number of items to write
+	maxAge      time.Duration  // if positive, maximum age of tweets to write
+	title       string         // if non-empty, overrides the feed's title
+	filters     filterSet      // rules for including and excluding tweets
+	templates   *feedTemplates // if non-nil, used to customize feed and item text
+	email       emailOptions   // used by emailFormat
+}
+
 const (
 	titleLen                      = 80   // max length of title text in feed, in runes
 	defaultMode       os.FileMode = 0644 // default mode for new feed files
@@ -43,40 +73,127 @@ const (
 var verbose = false // enable verbose logging
 
 func main() {
+	if len(os.Args) > 1 {
+		var run func([]string) error
+		switch os.Args[1] {
+		case "opml":
+			run = runOPML
+		case "site":
+			run = runSite
+		}
+		if run != nil {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			os.Exit(0)
+		}
+	}
+
 	var fetchOpts fetchOptions
 	var parseOpts parseOptions
+	var feedOpts feedOptions
 
 	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flag]... <user> <file>\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flag]... <user>[,<user>]... <file>\n", os.Args[0])
 		fmt.Fprintln(flag.CommandLine.Output(), "Creates an RSS feed from a Twitter user's timeline.")
+		fmt.Fprintln(flag.CommandLine.Output(), "Pass multiple comma-separated users to combine their timelines.")
 		fmt.Fprintln(flag.CommandLine.Output(), "Pass '-' for <file> to write feed to stdout.")
+		fmt.Fprintf(flag.CommandLine.Output(), "Run '%s opml' to export or import users as OPML.\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Run '%s site' to write a static HTML site from archives.\n", os.Args[0])
 		fmt.Fprintln(flag.CommandLine.Output(), "Flags:")
 		flag.PrintDefaults()
 	}
+	archiveDir := flag.String("archive-dir", "", "Directory for per-user archives of previously-seen tweets")
+	archiveMaxDays := flag.Int("archive-max-days", 0, "Maximum age of archived tweets in days (0 for no limit)")
+	archiveMaxItems := flag.Int("archive-max-items", 0, "Maximum number of archived tweets (0 for no limit)")
 	browserSize := flag.String("browser-size", "1024x8192", "Browser viewport size")
 	flag.StringVar(&fetchOpts.cacheDir, "cache-dir", "", "Chrome cache directory")
+	flag.BoolVar(&fetchOpts.captureVideos, "capture-videos", true, "Capture real video URLs from network traffic")
+	contentTypeFlag := flag.String("content-type", "html", `Format for feed item content ("html", "text", "markdown")`)
 	flag.BoolVar(&fetchOpts.logDebug, "debug-chrome", false, "Log noisy Chrome debug messages")
 	debugFile := flag.String("debug-file", "", "HTML timeline file to parse for debugging")
 	dumpDOM := flag.Bool("dump-dom", false, "Dump the timeline DOM to stdout for debugging")
+	dumpRulesFlag := flag.Bool("dump-rules", false, "Dump the current selector rules as JSON and exit")
+	flag.StringVar(&feedOpts.email.from, "email-from", "", `Sender of email digests (e.g. "twittuh <twittuh@example.org>")`)
+	emailTo := flag.String("email-to", "", "Comma-separated recipients of email digests")
+	flag.BoolVar(&parseOpts.expandLinks, "expand-links", false, "Rewrite t.co links to point at their destinations")
+	flag.BoolVar(&fetchOpts.expandTweets, "expand-tweets", true, `Expand long tweets truncated with "Show more"`)
+	var excludeRules, includeRules filterRulesFlag
+	flag.Var(&excludeRules, "exclude", "Rule describing tweets to skip (repeatable; see README)")
 	fetchRetries := flag.Int("fetch-retries", 0, "Number of times to retry fetching")
 	fetchTimeoutSec := flag.Int("fetch-timeout", 0, "Fetch timeout in seconds")
+	filtersFile := flag.String("filters", "", `JSON file with "include" and "exclude" lists of filter rules`)
 	force := flag.Bool("force", false, "Write feed even if there are no new tweets")
-	formatFlag := flag.String("format", "atom", `Feed format to write ("atom", "json", "rss")`)
+	formatFlag := flag.String("format", "atom", `Feed format to write ("atom", "json", "rss", "activitystreams", "email")`)
+	fromArchive := flag.Bool("from-archive", false, "Build feed from -archive-dir without fetching timelines")
+	imageSizeFlag := flag.String("image-size", "", `Size for tweet images ("small", "medium", "large", "orig")`)
+	flag.BoolVar(&parseOpts.imageSrcset, "image-srcset", false, "Add srcset attributes to tweet images")
+	flag.Var(&includeRules, "include", "Rule describing tweets to include (repeatable; see README)")
+	langsStr := flag.String("langs", "", `Comma-separated languages of tweets to include (e.g. "en,es")`)
+	linkCache := flag.String("link-cache", "", "JSON file for caching t.co destinations looked up by -expand-links")
+	maxDays := flag.Int("max-days", 0, "Maximum age of feed items in days (0 for no limit)")
+	flag.IntVar(&feedOpts.maxItems, "max-items", 0, "Maximum number of feed items (0 for no limit)")
+	flag.Float64Var(&parseOpts.maxSkipRatio, "max-skip-ratio", 0.5,
+		"Maximum fraction of unparsable tweets to skip before failing")
 	flag.StringVar(&fetchOpts.proxy, "proxy", "", `Optional proxy server (e.g. "socks5://localhost:9050")`)
 	pageSettleDelay := flag.Int("page-settle-delay", 2, "Seconds to wait for page render")
-	replies := flag.Bool("replies", false, "Include the user's replies")
+	pinnedFlag := flag.String("pinned", "include", `How to handle pinned tweet ("include", "skip", "new")`)
+	flag.BoolVar(&feedOpts.replies, "replies", false, "Include the user's replies")
+	rulesFile := flag.String("rules", "", "JSON file overriding built-in selector rules")
+	flag.BoolVar(&parseOpts.sanitize, "sanitize", true, "Strip unsafe or unneeded HTML from tweet content")
 	flag.BoolVar(&fetchOpts.showSensitive, "show-sensitive", true, "Show sensitive content in tweets")
 	serveAddr := flag.String("serve", "", `Listen for requests over HTTP (e.g. "0.0.0.0:8080")`)
+	serveCacheSec := flag.Int("serve-cache", 0, "Seconds to reuse fetched timelines across -serve requests")
 	showSensitiveDelay := flag.Int("show-sensitive-delay", 2, "Seconds to wait after showing sensitive content")
+	skipLangsStr := flag.String("skip-langs", "", `Comma-separated languages whose tweets should be skipped (e.g. "es,fr")`)
 	skipUsersStr := flag.String("skip-users", "", "Comma-separated users whose tweets should be skipped")
+	flag.StringVar(&feedOpts.email.smtpAddr, "smtp-addr", "", `SMTP server for sending email digests (e.g. "smtp.example.org:587")`)
+	smtpPassFile := flag.String("smtp-password-file", "", "File containing password for -smtp-user")
+	flag.StringVar(&feedOpts.email.smtpUser, "smtp-user", "", "Username for authenticating to -smtp-addr")
 	flag.BoolVar(&parseOpts.simplify, "simplify", true, "Simplify HTML in feed")
+	flag.BoolVar(&feedOpts.threads, "threads", false, "Merge threads of self-replies into single items")
+	templatesFile := flag.String("templates", "", "JSON file with templates for feed and item text")
+	flag.StringVar(&feedOpts.title, "title", "", "Feed title (defaults to user's name)")
 	torControlAddr := flag.String("tor-control", "", `Interface for resetting Tor circuits after fetch fails (e.g. "0.0.0.0:9051")`)
 	tweetTimeout := flag.Int("tweet-timeout", 0, "Timeout for loading tweets in seconds")
+	validate := flag.Bool("validate-rules", false, "Parse HTML timeline files passed as args using rules and exit")
 	flag.BoolVar(&verbose, "verbose", false, "Enable verbose logging")
 	flag.Parse()
 
+	if *imageSizeFlag != "" {
+		if parseOpts.imageSize = imageSize(*imageSizeFlag); !validImageSize(parseOpts.imageSize) {
+			log.Fatalf("Bad image size %q", *imageSizeFlag)
+		}
+	}
+
+	if *rulesFile != "" {
+		var err error
+		if rules, err = loadRules(*rulesFile); err != nil {
+			log.Fatalf("Failed loading rules from %v: %v", *rulesFile, err)
+		}
+	}
+	if *dumpRulesFlag {
+		if err := dumpRules(); err != nil {
+			log.Fatal("Failed dumping rules: ", err)
+		}
+		os.Exit(0)
+	}
+	if *validate {
+		if err := validateRules(flag.Args(), parseOpts); err != nil {
+			log.Fatal("Validation failed: ", err)
+		}
+		os.Exit(0)
+	}
+
+	if parseOpts.expandLinks {
+		var err error
+		if parseOpts.resolver, err = newLinkResolver(fetchOpts.proxy, *linkCache); err != nil {
+			log.Fatal("Failed creating link resolver: ", err)
+		}
+	}
+
 	if *debugFile != "" {
-		if err := debugParse(*debugFile, parseOpts, *replies); err != nil {
+		if err := debugParse(*debugFile, parseOpts, feedOpts.replies); err != nil {
 			log.Fatal("Failed reading timeline: ", err)
 		}
 		os.Exit(0)
@@ -98,47 +215,196 @@ func main() {
 	fetchOpts.tweetTimeout = time.Duration(*tweetTimeout) * time.Second
 
 	format := feedFormat(*formatFlag)
+	feedOpts.pinned = pinnedMode(*pinnedFlag)
+	feedOpts.contentType = contentType(*contentTypeFlag)
+	if *skipUsersStr != "" {
+		feedOpts.skipUsers = strings.Split(*skipUsersStr, ",")
+	}
+	if *langsStr != "" {
+		feedOpts.langs = strings.Split(*langsStr, ",")
+	}
+	if *skipLangsStr != "" {
+		feedOpts.skipLangs = strings.Split(*skipLangsStr, ",")
+	}
+	feedOpts.maxAge = time.Duration(*maxDays) * 24 * time.Hour
+	archiveLim := archiveLimits{
+		maxItems: *archiveMaxItems,
+		maxAge:   time.Duration(*archiveMaxDays) * 24 * time.Hour,
+	}
+	if *filtersFile != "" {
+		var err error
+		if feedOpts.filters, err = loadFilters(*filtersFile); err != nil {
+			log.Fatalf("Failed loading filters from %v: %v", *filtersFile, err)
+		}
+	}
+	if err := feedOpts.filters.addRules(includeRules, excludeRules); err != nil {
+		log.Fatal("Bad filter rule: ", err)
+	}
+	if *templatesFile != "" {
+		var err error
+		if feedOpts.templates, err = loadTemplates(*templatesFile); err != nil {
+			log.Fatalf("Failed loading templates from %v: %v", *templatesFile, err)
+		}
+	}
+	if *emailTo != "" {
+		feedOpts.email.to = strings.Split(*emailTo, ",")
+	}
+	if *smtpPassFile != "" {
+		b, err := ioutil.ReadFile(*smtpPassFile)
+		if err != nil {
+			log.Fatal("Failed reading SMTP password: ", err)
+		}
+		feedOpts.email.smtpPass = strings.TrimSpace(string(b))
+	}
 	fetchTimeout := time.Duration(*fetchTimeoutSec) * time.Second
 
+	// getTimeline fetches user's timeline (or reads it from the archive if fromArchive is true)
+	// and merges it into the user's archive if -archive-dir was supplied.
+	getTimeline := func(ctx context.Context, user string, fromArchive bool) (timeline, error) {
+		var tl timeline
+		var err error
+		if fromArchive {
+			if *archiveDir == "" {
+				return tl, errors.New("-from-archive requires -archive-dir")
+			}
+			ar, err := readArchive(archivePath(*archiveDir, user))
+			if err != nil {
+				return tl, err
+			} else if len(ar.Tweets) == 0 {
+				return tl, errors.New("no archived tweets")
+			}
+			return timeline{prof: ar.Profile, tweets: ar.Tweets}, nil
+		}
+		tl.prof, tl.tweets, tl.warnings, err = fetchUser(ctx, user, fetchOpts, parseOpts, fetchTimeout, *fetchRetries)
+		if err != nil {
+			return tl, err
+		}
+		if *archiveDir != "" {
+			if tl.tweets, err = updateArchive(*archiveDir, user, tl.prof, tl.tweets, archiveLim); err != nil {
+				return tl, fmt.Errorf("failed updating archive: %v", err)
+			}
+		}
+		return tl, nil
+	}
+
 	if *serveAddr != "" {
+		if feedOpts.pinned == newPinned {
+			log.Fatal(`-pinned new requires a feed file and can't be used with -serve`)
+		}
+		var cache *timelineCache
+		if *serveCacheSec > 0 {
+			cache = newTimelineCache(time.Duration(*serveCacheSec) * time.Second)
+		}
+
 		// Handle HTTP requests.
 		http.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 			ctx := req.Context()
-			user := bareUser(req.FormValue("user"))
-			log.Printf("Got request from %v for %v", req.RemoteAddr, user)
-			if user == "" {
+			users := splitUsers(req.FormValue("user"))
+			log.Printf("Got request from %v for %v", req.RemoteAddr, strings.Join(users, ","))
+			if len(users) == 0 {
 				http.Error(w, "No user specified", http.StatusInternalServerError)
 				return
 			}
 
-			prof, tweets, err := fetchUser(ctx, user, fetchOpts, parseOpts, fetchTimeout, *fetchRetries)
+			// Partial combined feeds are served so that one failing user doesn't break the feed.
+			tls, err := getTimelines(users, true, func(user string) (timeline, error) {
+				if cache != nil {
+					if tl, ok := cache.get(user); ok {
+						debugf("Using cached timeline for %v", user)
+						return tl, nil
+					}
+				}
+				tl, err := getTimeline(ctx, user, false)
+				for _, pw := range tl.warnings {
+					w.Header().Add("X-Parse-Warning", pw.String())
+				}
+				if err != nil && err != errTweetsProtected && *torControlAddr != "" {
+					log.Printf("Sending NEWNYM command to %v to reset Tor circuits", *torControlAddr)
+					if err := resetTorCircuits(*torControlAddr); err != nil {
+						log.Print("Failed resetting Tor circuits: ", err)
+					}
+				}
+				if err == nil && cache != nil {
+					cache.put(user, tl)
+				}
+				return tl, err
+			})
 			if err != nil {
-				msg := fmt.Sprintf("Failed getting %v: %v", user, err)
+				msg := fmt.Sprintf("Failed getting %v: %v", strings.Join(users, ","), err)
 				log.Print(msg)
-				if err == errTweetsProtected {
+				if errors.Is(err, errTweetsProtected) {
 					http.Error(w, msg, http.StatusUnauthorized)
 				} else {
 					http.Error(w, msg, http.StatusInternalServerError)
-					if *torControlAddr != "" {
-						log.Printf("Sending NEWNYM command to %v to reset Tor circuits", *torControlAddr)
-						if err := resetTorCircuits(*torControlAddr); err != nil {
-							log.Print("Failed resetting Tor circuits: ", err)
-						}
-					}
 				}
 				return
 			}
 
-			format := format // shadow value from flag
+			format := format     // shadow value from flag
+			feedOpts := feedOpts // shadow value from flags
 			if f := req.FormValue("format"); f != "" {
 				format = feedFormat(f)
 			}
-			var skipUsers []string
+			if format == emailFormat {
+				http.Error(w, "Email digests can't be served", http.StatusBadRequest)
+				return
+			}
+			if ct := req.FormValue("contentType"); ct != "" {
+				feedOpts.contentType = contentType(ct)
+			}
 			if s := req.FormValue("skipUsers"); s != "" {
-				skipUsers = strings.Split(s, ",")
+				feedOpts.skipUsers = strings.Split(s, ",")
+			}
+			if s := req.FormValue("langs"); s != "" {
+				feedOpts.langs = strings.Split(s, ",")
+			}
+			if s := req.FormValue("skipLangs"); s != "" {
+				feedOpts.skipLangs = strings.Split(s, ",")
+			}
+			if p := req.FormValue("pinned"); p != "" {
+				// There's no previous feed to compare against when serving.
+				if feedOpts.pinned = pinnedMode(p); feedOpts.pinned == newPinned {
+					http.Error(w, `pinned value "new" requires a feed file`, http.StatusBadRequest)
+					return
+				}
+			}
+			if s := req.FormValue("maxItems"); s != "" {
+				if feedOpts.maxItems, err = strconv.Atoi(s); err != nil {
+					http.Error(w, fmt.Sprintf("Bad maxItems value %q", s), http.StatusBadRequest)
+					return
+				}
+			}
+			if s := req.FormValue("maxDays"); s != "" {
+				days, err := strconv.Atoi(s)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Bad maxDays value %q", s), http.StatusBadRequest)
+					return
+				}
+				feedOpts.maxAge = time.Duration(days) * 24 * time.Hour
+			}
+			if t := req.FormValue("threads"); t != "" {
+				if feedOpts.threads, err = strconv.ParseBool(t); err != nil {
+					http.Error(w, fmt.Sprintf("Bad threads value %q", t), http.StatusBadRequest)
+					return
+				}
+			}
+			if inc, exc := req.Form["include"], req.Form["exclude"]; len(inc) > 0 || len(exc) > 0 {
+				// Copy the rules from the flags to avoid modifying them.
+				fs := filterSet{
+					Include: append([]filterRule(nil), feedOpts.filters.Include...),
+					Exclude: append([]filterRule(nil), feedOpts.filters.Exclude...),
+				}
+				if err := fs.addRules(inc, exc); err != nil {
+					http.Error(w, fmt.Sprintf("Bad filter rule: %v", err), http.StatusBadRequest)
+					return
+				}
+				feedOpts.filters = fs
+			}
+			if t := req.FormValue("title"); t != "" {
+				feedOpts.title = t
 			}
-			if err := writeFeed(w, format, prof, tweets, *replies, skipUsers); err != nil {
-				msg := fmt.Sprintf("Failed writing %v: %v", user, err)
+			if err := writeFeed(w, format, timelineProfiles(tls), combineTimelines(tls), feedOpts); err != nil {
+				msg := fmt.Sprintf("Failed writing %v: %v", strings.Join(users, ","), err)
 				log.Print(msg)
 				http.Error(w, msg, http.StatusInternalServerError)
 				return
@@ -147,20 +413,23 @@ func main() {
 		log.Printf("Listening on %v", *serveAddr)
 		log.Fatal(http.ListenAndServe(*serveAddr, nil))
 	} else {
-		// Process a single timeline.
+		// Process a single timeline or combine several.
 		if len(flag.Args()) != 2 && !*dumpDOM {
 			flag.Usage()
 			os.Exit(2)
 		}
 
 		ctx := context.Background()
-		user := bareUser(flag.Arg(0))
+		users := splitUsers(flag.Arg(0))
+		if len(users) == 0 {
+			log.Fatalf("No users in %q", flag.Arg(0))
+		}
 		feedPath := flag.Arg(1)
 		useStdout := feedPath == "-"
 
 		// If we're dumping the DOM, just try to fetch the timeline once.
 		if *dumpDOM {
-			dom, err := fetchTimeline(ctx, user, fetchOpts)
+			dom, err := fetchTimeline(ctx, users[0], fetchOpts)
 			if err != nil {
 				log.Fatal("Failed fetching timeline: ", err)
 			}
@@ -168,68 +437,72 @@ func main() {
 			os.Exit(0)
 		}
 
+		// Saved digests record which tweets were sent, so they must actually be sent.
+		if format == emailFormat && !useStdout && feedOpts.email.smtpAddr == "" {
+			log.Fatal("-format email requires -smtp-addr unless writing to stdout")
+		}
+
 		// Get the latest ID from the old copy of the feed so we can check for new
 		// tweets before rewriting it.
 		var oldLatestID int64
 		var err error
-		if !*force && !useStdout {
+		if !useStdout && (!*force || feedOpts.pinned == newPinned || format == emailFormat) {
 			if oldLatestID, err = getFeedLatestID(feedPath, format); err != nil {
 				log.Printf("Couldn't get old latest ID from %v: %v", feedPath, err)
 			}
 		}
+		feedOpts.oldLatestID = oldLatestID
 
-		prof, tweets, err := fetchUser(ctx, user, fetchOpts, parseOpts, fetchTimeout, *fetchRetries)
+		// Fail rather than writing a combined feed that is missing some users' tweets.
+		tls, err := getTimelines(users, false, func(user string) (timeline, error) {
+			return getTimeline(ctx, user, *fromArchive)
+		})
 		if err != nil {
-			log.Fatalf("Failed getting %v: %v", user, err)
+			log.Fatalf("Failed getting %v: %v", strings.Join(users, ","), err)
 		}
-
-		if getTweetsLatestID(tweets) == oldLatestID {
+		tweets := combineTimelines(tls)
+		if !*force && getTweetsLatestID(tweets) == oldLatestID {
 			debug("No new tweets; exiting without writing feed")
 			os.Exit(0)
 		}
 
-		var f *os.File
+		write := func(w io.Writer) error { return writeFeed(w, format, timelineProfiles(tls), tweets, feedOpts) }
+		if format == emailFormat {
+			// Send the digest before saving it so that it'll be resent by the next run if sending fails.
+			var b bytes.Buffer
+			if err := write(&b); errors.Is(err, errEmptyDigest) {
+				debug("No new tweets for digest; exiting without sending")
+				os.Exit(0)
+			} else if err != nil {
+				log.Fatal("Failed writing digest: ", err)
+			}
+			if !useStdout {
+				if err := sendEmail(feedOpts.email, b.Bytes()); err != nil {
+					log.Fatal("Failed sending digest: ", err)
+				}
+			}
+			write = func(w io.Writer) error {
+				_, err := w.Write(b.Bytes())
+				return err
+			}
+		}
 		if useStdout {
-			f = os.Stdout
+			err = write(os.Stdout)
 		} else {
 			// Write to a temp file and then replace the feed atomically to preserve the old version if
 			// something goes wrong.
-			if f, err = ioutil.TempFile(filepath.Dir(feedPath), "."+filepath.Base(feedPath)+"."); err != nil {
-				log.Fatal("Failed creating feed file: ", err)
-			}
-			defer os.Remove(f.Name()) // silently fails if we successfully rename temp file
+			err = writeFileAtomic(feedPath, write)
 		}
-
-		var skipUsers []string
-		if *skipUsersStr != "" {
-			skipUsers = strings.Split(*skipUsersStr, ",")
-		}
-		if err := writeFeed(f, format, prof, tweets, *replies, skipUsers); err != nil {
-			f.Close()
+		if err != nil {
 			log.Fatal("Failed writing feed: ", err)
 		}
-		if err := f.Close(); err != nil {
-			log.Fatal("Failed closing feed file: ", err)
-		}
-
-		if !useStdout {
-			mode := defaultMode // ioutil.TempFile seems to use 0600 by default
-			if fi, err := os.Stat(feedPath); err == nil {
-				mode = fi.Mode()
-			}
-			if err := os.Chmod(f.Name(), mode); err != nil {
-				log.Print("Failed setting mode: ", err)
-			}
-			if err := os.Rename(f.Name(), feedPath); err != nil {
-				log.Fatal("Failed replacing feed file: ", err)
-			}
-		}
 	}
 }
 
 // fetchUser fetches the profile and tweets from the supplied user's timeline.
+// Tweets that were skipped due to parsing errors are logged and described by the returned warnings.
 func fetchUser(ctx context.Context, user string, fetchOpts fetchOptions, parseOpts parseOptions,
-	fetchTimeout time.Duration, fetchRetries int) (prof profile, tweets []tweet, err error) {
+	fetchTimeout time.Duration, fetchRetries int) (prof profile, tweets []tweet, warnings []parseWarning, err error) {
 	debugf("Getting timeline for %v", user)
 	var dom string
 	var attempts int
@@ -244,37 +517,158 @@ func fetchUser(ctx context.Context, user string, fetchOpts fetchOptions, parseOp
 			break
 		} else {
 			if attempts > fetchRetries {
-				return prof, nil, fmt.Errorf("failed fetching timeline: %v", err)
+				return prof, nil, nil, fmt.Errorf("failed fetching timeline: %w", err)
 			} else {
 				debugf("Fetching timeline failed; trying again: %v", err)
 			}
 		}
 	}
 
-	prof, tweets, err = parseTimeline(strings.NewReader(dom), parseOpts)
+	prof, tweets, warnings, err = parseTimeline(strings.NewReader(dom), user, parseOpts)
+	for _, w := range warnings {
+		log.Printf("Skipped %v for %v", w, user)
+	}
+	if parseOpts.resolver != nil {
+		if err := parseOpts.resolver.save(); err != nil {
+			log.Print("Failed saving link cache: ", err)
+		}
+	}
 	if err != nil {
-		return prof, nil, fmt.Errorf("failed parsing timeline: %v", err)
+		return prof, nil, warnings, fmt.Errorf("failed parsing timeline: %v", err)
 	} else if len(tweets) == 0 {
-		return prof, nil, errors.New("no tweets found")
+		return prof, nil, warnings, errors.New("no tweets found")
 	}
 	debugf("Parsed %v tweet(s)", len(tweets))
-	return prof, tweets, nil
+	return prof, tweets, warnings, nil
+}
+
+// writeFeed writes a feed in the supplied format containing tweets from one or more users' timelines.
+// If multiple profiles are supplied, the feed combines their timelines (see combineTimelines).
+func writeFeed(w io.Writer, format feedFormat, profs []profile, tweets []tweet, opts feedOptions) error {
+	if format == emailFormat {
+		return writeDigest(w, profs, tweets, opts)
+	}
+
+	// Compute the latest ID before buildFeed merges threads so that new parts will update the feed.
+	latestID := getTweetsLatestID(tweets)
+	feed, itemTweets, err := buildFeed(profs, tweets, opts)
+	if err != nil {
+		return err
+	}
+	debugf("Writing feed with %v item(s) and latest ID %v", len(feed.Items), latestID)
+
+	// Only use the profile's images if the feed contains a single user's timeline.
+	var prof profile
+	if len(profs) == 1 {
+		prof = profs[0]
+	}
+
+	switch format {
+	case jsonFormat:
+		// Embed the latest ID in the feed's UserComment field.
+		// The marshaling here matches feeds.Feed.WriteJSON().
+		jf := newJSONFeed(feed, itemTweets, opts.contentType)
+		jf.UserComment = fmt.Sprintf("latest id %v", latestID)
+		jf.Favicon = prof.Icon
+		jf.Icon = prof.Image
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(jf)
+	case activityFormat:
+		// Single timelines are written as actors with embedded outboxes.
+		coll := newActivityCollection(feed, profs, itemTweets, opts.contentType)
+		coll.LatestID = strconv.FormatInt(latestID, 10)
+		var out interface{} = coll
+		if len(profs) == 1 {
+			out = newActivityActor(prof, coll)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	case atomFormat, rssFormat:
+		var xf feeds.XmlFeed
+		if format == atomFormat {
+			af := newAtomFeed(feed, itemTweets, opts.contentType)
+			af.Icon = prof.Image
+			af.Logo = prof.Banner // Atom logos should be wider than they are tall
+			xf = af
+		} else {
+			xf = newRSSFeed(feed, itemTweets, opts.contentType)
+		}
+		if err := feeds.WriteXML(xf, w); err != nil {
+			return err
+		}
+		// Embed the latest ID in a trailing comment.
+		_, err := fmt.Fprintf(w, "\n<!-- latest id %v -->\n", latestID)
+		return err
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
 }
 
-// writeFeed writes a feed in the supplied format containing tweets from a user's timeline.
-// If replies is true, the user's replies will also be included.
-func writeFeed(w io.Writer, format feedFormat, prof profile, tweets []tweet,
-	replies bool, skipUsers []string) error {
+// buildFeed returns a feed containing the tweets from one or more users' timelines that should be
+// written by writeFeed, along with the tweet corresponding to each of the feed's items.
+func buildFeed(profs []profile, tweets []tweet, opts feedOptions) (*feeds.Feed, []tweet, error) {
+	switch opts.pinned {
+	case includePinned, skipPinned, newPinned:
+	default:
+		return nil, nil, fmt.Errorf("unknown pinned mode %q", opts.pinned)
+	}
+	switch opts.contentType {
+	case htmlContent, textContent, markdownContent:
+	default:
+		return nil, nil, fmt.Errorf("unknown content type %q", opts.contentType)
+	}
+	if len(profs) == 0 {
+		return nil, nil, errors.New("no profiles")
+	}
+
+	// Only use the profile's images if the feed contains a single user's timeline.
+	var prof profile
+	if len(profs) == 1 {
+		prof = profs[0]
+	}
+
 	author := prof.displayName()
 	feedDesc := "Tweets"
-	if replies {
+	if opts.replies {
 		feedDesc += " and replies"
 	}
-	feedDesc += fmt.Sprintf(" from @%v's timeline", prof.User)
+	if len(profs) == 1 {
+		feedDesc += fmt.Sprintf(" from @%v's timeline", prof.User)
+		if prof.Bio != "" {
+			feedDesc = prof.Bio
+		}
+	} else {
+		var names, users []string
+		for _, p := range profs {
+			names = append(names, p.Name)
+			users = append(users, "@"+p.User)
+		}
+		author = strings.Join(names, ", ")
+		feedDesc += " from " + strings.Join(users, ", ")
+	}
+	if opts.title != "" {
+		author = opts.title
+	}
+	feedTitle, feedDesc, err := opts.templates.feedText(feedTemplateData{
+		Profile:     profs[0],
+		Profiles:    profs,
+		Title:       author,
+		Description: feedDesc,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
 
+	// Combined feeds don't have a single page on Twitter to link to.
+	feedLink := &feeds.Link{}
+	if len(profs) == 1 {
+		feedLink.Href = userURL(prof.User)
+	}
 	feed := &feeds.Feed{
-		Title:       author,
-		Link:        &feeds.Link{Href: userURL(prof.User)},
+		Title:       feedTitle,
+		Link:        feedLink,
 		Description: feedDesc,
 		Author:      &feeds.Author{Name: author},
 		Updated:     time.Now(),
@@ -284,66 +678,87 @@ func writeFeed(w io.Writer, format feedFormat, prof profile, tweets []tweet,
 		feed.Image = &feeds.Image{Url: prof.Image}
 	}
 
+	// Tweets from the users whose timelines are included are never skipped.
+	timelineUsers := make(map[string]struct{}, len(profs))
+	for _, p := range profs {
+		timelineUsers[strings.ToLower(p.User)] = struct{}{}
+	}
+
 	// User-supplied names may not have the canonical casing.
 	skipUsersMap := make(map[string]struct{})
-	for _, u := range skipUsers {
+	for _, u := range opts.skipUsers {
 		skipUsersMap[strings.ToLower(bareUser(u))] = struct{}{}
 	}
 
+	if opts.threads {
+		tweets = mergeThreads(tweets)
+	}
+
+	// skipReason returns a description of why t should be omitted from the feed,
+	// or an empty string if it should be included.
+	skipReason := func(t *tweet) string {
+		if opts.maxAge > 0 && time.Since(t.Time) > opts.maxAge {
+			return "too old"
+		}
+		if !opts.replies && t.reply() {
+			return "reply"
+		}
+		if t.Pinned && (opts.pinned == skipPinned ||
+			(opts.pinned == newPinned && t.ID <= opts.oldLatestID)) {
+			return "pinned"
+		}
+		if _, ok := skipUsersMap[strings.ToLower(t.User)]; ok {
+			if _, own := timelineUsers[strings.ToLower(t.User)]; !own {
+				return "skipped user @" + t.User
+			}
+		}
+		if !t.hasLang(opts.langs, opts.skipLangs) {
+			return "skipped language " + t.Lang
+		}
+		return opts.filters.check(t)
+	}
+
+	var itemTweets []tweet // tweet corresponding to each item in feed
 	for _, t := range tweets {
-		if !replies && t.reply() {
-			continue
+		if opts.maxItems > 0 && len(itemTweets) >= opts.maxItems {
+			break
 		}
-		if _, ok := skipUsersMap[strings.ToLower(t.User)]; ok && t.User != prof.User {
+		if reason := skipReason(&t); reason != "" {
+			debugf("Skipping %v: %v", t.ID, reason)
 			continue
 		}
 
+		updated := t.Time
+		if !t.Updated.IsZero() {
+			updated = t.Updated
+		}
+		content, err := t.renderContent(opts.contentType)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed rendering %v: %v", t.ID, err)
+		}
+		desc, err := t.renderContent(textContent)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed rendering %v: %v", t.ID, err)
+		}
+		title, content, err := opts.templates.itemText(&t, profs[0], content, opts.contentType)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed executing templates for %v: %v", t.ID, err)
+		}
 		item := &feeds.Item{
-			Title:       t.Text,
+			Title:       title,
 			Link:        &feeds.Link{Href: t.Href}, // Atom's default rel is "alternate"
-			Description: t.Text,
+			Description: desc,
 			Author:      &feeds.Author{Name: t.displayName()},
 			Id:          fmt.Sprintf("%v", t.ID),
 			Created:     t.Time,
-			Updated:     t.Time,
-			Content:     t.Content,
-		}
-		if ut := []rune(item.Title); len(ut) > titleLen {
-			item.Title = string(ut[:titleLen-1]) + "…"
+			Updated:     updated,
+			Content:     content,
 		}
+		item.Title = truncate(titleLen, item.Title)
 		feed.Add(item)
+		itemTweets = append(itemTweets, t)
 	}
-
-	latestID := getTweetsLatestID(tweets)
-	debugf("Writing feed with %v item(s) and latest ID %v", len(feed.Items), latestID)
-
-	switch format {
-	case jsonFormat:
-		// Embed the latest ID in the feed's UserComment field.
-		// The marshaling here matches feeds.Feed.WriteJSON().
-		jf := (&feeds.JSON{Feed: feed}).JSONFeed()
-		jf.UserComment = fmt.Sprintf("latest id %v", latestID)
-		jf.Favicon = prof.Icon
-		jf.Icon = prof.Image
-		enc := json.NewEncoder(w)
-		enc.SetIndent("", "  ")
-		return enc.Encode(jf)
-	case atomFormat, rssFormat:
-		var err error
-		if format == atomFormat {
-			err = feed.WriteAtom(w)
-		} else {
-			err = feed.WriteRss(w)
-		}
-		if err != nil {
-			return err
-		}
-		// Embed the latest ID in a trailing comment.
-		_, err = fmt.Fprintf(w, "\n<!-- latest id %v -->\n", latestID)
-		return err
-	default:
-		return fmt.Errorf("unknown format %q", format)
-	}
+	return feed, itemTweets, nil
 }
 
 // These match the comments added by writeFeed.
@@ -381,6 +796,30 @@ func getFeedLatestID(p string, format feedFormat) (int64, error) {
 			return 0, errors.New("failed unmarshaling feed")
 		}
 		matches = jsonLatestIDRegexp.FindStringSubmatch(feed.UserComment)
+	case activityFormat:
+		var doc struct {
+			LatestID string `json:"_latestId"` // set if the feed is a collection
+			Outbox   struct {
+				LatestID string `json:"_latestId"`
+			} `json:"outbox"` // set if the feed is an actor
+		}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			return 0, errors.New("failed unmarshaling feed")
+		}
+		for _, id := range []string{doc.LatestID, doc.Outbox.LatestID} {
+			if id != "" {
+				return strconv.ParseInt(id, 10, 64)
+			}
+		}
+	case emailFormat:
+		msg, err := mail.ReadMessage(bytes.NewReader(b))
+		if err != nil {
+			return 0, errors.New("failed reading message")
+		}
+		if id := msg.Header.Get(latestIDHeader); id != "" {
+			return strconv.ParseInt(id, 10, 64)
+		}
+		return 0, errors.New("couldn't find latest ID in header")
 	}
 	if matches == nil {
 		return 0, errors.New("couldn't find latest ID in comment")
@@ -427,7 +866,10 @@ func debugParse(p string, opts parseOptions, replies bool) error {
 	}
 	defer f.Close()
 
-	prof, tweets, err := parseTimeline(f, opts)
+	prof, tweets, warnings, err := parseTimeline(f, "", opts)
+	for _, w := range warnings {
+		fmt.Printf("Skipped %v\n", w)
+	}
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,298 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// imageSize describes the variants of tweet images served by pbs.twimg.com.
+type imageSize string
+
+const (
+	smallImage  imageSize = "small"  // 680 pixels on longest side
+	mediumImage imageSize = "medium" // 1200 pixels on longest side
+	largeImage  imageSize = "large"  // 2048 pixels on longest side
+	origImage   imageSize = "orig"   // originally-uploaded image
+)
+
+// srcsetSizes lists the image variants used in srcset attributes and their widths.
+var srcsetSizes = []struct {
+	size  imageSize
+	width int
+}{
+	{smallImage, 680},
+	{mediumImage, 1200},
+	{largeImage, 2048},
+}
+
+// validImageSize returns true if s is a supported image size.
+func validImageSize(s imageSize) bool {
+	switch s {
+	case smallImage, mediumImage, largeImage, origImage:
+		return true
+	default:
+		return false
+	}
+}
+
+// isMediaURL returns true if u is a pbs.twimg.com URL for an image attached to a tweet.
+func isMediaURL(u *url.URL) bool {
+	return u.Host == "pbs.twimg.com" && strings.HasPrefix(u.Path, "/media/")
+}
+
+// mediaURL returns a copy of u (see isMediaURL) that requests the supplied image size.
+func mediaURL(u *url.URL, size imageSize) string {
+	c := *u
+	q := c.Query()
+	q.Set("name", string(size))
+	c.RawQuery = q.Encode()
+	return c.String()
+}
+
+// normalizeImages rewrites tweet images under n to use the supplied size
+// (or leaves them unchanged if size is empty), optionally adding srcset attributes
+// listing other sizes. The empty divs that Twitter uses to reserve space for
+// images are also removed.
+func normalizeImages(n *html.Node, size imageSize, srcset bool) {
+	for _, img := range findNodes(n, matchFunc("img", "src")) {
+		u, err := url.Parse(getAttr(img, "src"))
+		if err != nil || !isMediaURL(u) {
+			continue
+		}
+		if size != "" {
+			setAttr(img, "src", mediaURL(u, size))
+		}
+		if srcset {
+			var srcs []string
+			for _, s := range srcsetSizes {
+				srcs = append(srcs, fmt.Sprintf("%s %dw", mediaURL(u, s.size), s.width))
+			}
+			setAttr(img, "srcset", strings.Join(srcs, ", "))
+		}
+	}
+
+	// Placeholders look like <div style="padding-bottom: 56.25%;"></div>.
+	for _, div := range findNodes(n, func(n *html.Node) bool {
+		return isElement(n, "div") && n.FirstChild == nil &&
+			strings.HasPrefix(getAttr(n, "style"), "padding-bottom:") &&
+			strings.HasSuffix(getAttr(n, "style"), "%;")
+	}) {
+		div.Parent.RemoveChild(div)
+	}
+}
+
+// videoVariant describes a playable version of a video.
+// The JSON field names match the ones used by Twitter's API.
+type videoVariant struct {
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Bitrate     int    `json:"bitrate,omitempty"`
+}
+
+const (
+	mp4Type = "video/mp4"
+	hlsType = "application/x-mpegURL"
+)
+
+// videoSourcesID is the ID of the <script> element that fetchTimeline adds to the DOM to hold
+// the video variants that it captured, as a JSON-encoded map[string][]videoVariant keyed by
+// videoKey. Storing the variants in the DOM ensures that -dump-dom output includes them.
+const videoSourcesID = "twittuh-video-sources"
+
+// videoKey returns a key identifying the video that u belongs to. u can be either a poster
+// image URL (e.g. "https://pbs.twimg.com/ext_tw_video_thumb/1341135734565167104/pu/img/Db4r.jpg")
+// or a video URL (e.g. "https://video.twimg.com/ext_tw_video/1341135734565167104/pu/pl/abc.m3u8"),
+// and the key looks like "ext_tw_video/1341135734565167104". An empty string is returned if
+// u isn't recognized.
+func videoKey(u string) string {
+	pu, err := url.Parse(u)
+	if err != nil || (pu.Host != "pbs.twimg.com" && pu.Host != "video.twimg.com") {
+		return ""
+	}
+	parts := strings.Split(strings.TrimPrefix(pu.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return ""
+	}
+	kind := strings.TrimSuffix(parts[0], "_thumb")
+	id := parts[1]
+	if i := strings.IndexByte(id, '.'); i > 0 {
+		id = id[:i]
+	}
+	return kind + "/" + id
+}
+
+// videoContentType returns the MIME type of the video at u based on its extension.
+// An empty string is returned for unplayable URLs (e.g. HLS segments).
+func videoContentType(u string) string {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	switch path.Ext(pu.Path) {
+	case ".mp4":
+		return mp4Type
+	case ".m3u8":
+		return hlsType
+	default:
+		return ""
+	}
+}
+
+// addVideoVariant adds vr to vids under key if it isn't already present.
+func addVideoVariant(vids map[string][]videoVariant, key string, vr videoVariant) {
+	for _, o := range vids[key] {
+		if o.URL == vr.URL {
+			return
+		}
+	}
+	vids[key] = append(vids[key], vr)
+}
+
+// findVideoVariants recursively searches v, decoded JSON data from Twitter's API, for media
+// objects with video variants and adds them to vids.
+func findVideoVariants(v interface{}, vids map[string][]videoVariant) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		// Media objects look like {"media_url_https": "...", "video_info": {"variants": [...]}}.
+		poster, _ := v["media_url_https"].(string)
+		info, _ := v["video_info"].(map[string]interface{})
+		if key := videoKey(poster); key != "" && info != nil {
+			vars, _ := info["variants"].([]interface{})
+			for _, vr := range vars {
+				m, _ := vr.(map[string]interface{})
+				u, _ := m["url"].(string)
+				ct, _ := m["content_type"].(string)
+				br, _ := m["bitrate"].(float64)
+				if u != "" && ct != "" {
+					addVideoVariant(vids, key, videoVariant{URL: u, ContentType: ct, Bitrate: int(br)})
+				}
+			}
+		}
+		for _, c := range v {
+			findVideoVariants(c, vids)
+		}
+	case []interface{}:
+		for _, c := range v {
+			findVideoVariants(c, vids)
+		}
+	}
+}
+
+// readVideoVariants returns the video variants that fetchTimeline saved in root.
+// An empty map is returned if none were saved.
+func readVideoVariants(root *html.Node) map[string][]videoVariant {
+	vids := make(map[string][]videoVariant)
+	if n := findFirstNode(root, matchFunc("script", "id="+videoSourcesID)); n != nil {
+		if err := json.Unmarshal([]byte(getText(n, false)), &vids); err != nil {
+			debug("Failed reading video variants: ", err)
+		}
+	}
+	return vids
+}
+
+// gifVariants returns variants for the animated GIF with the supplied poster URL.
+// Twitter converts GIFs to MP4s, and their URLs can be derived from their posters.
+// Nil is returned if the poster doesn't belong to a GIF.
+func gifVariants(poster string) []videoVariant {
+	if key := videoKey(poster); strings.HasPrefix(key, "tweet_video/") {
+		return []videoVariant{{URL: "https://video.twimg.com/" + key + ".mp4", ContentType: mp4Type}}
+	}
+	return nil
+}
+
+// addVideoSources appends <source> elements to v for each of variants.
+// MP4s are listed first in descending order of bitrate, followed by HLS playlists.
+func addVideoSources(v *html.Node, variants []videoVariant) {
+	vs := append([]videoVariant(nil), variants...)
+	sort.SliceStable(vs, func(i, j int) bool {
+		if mi, mj := vs[i].ContentType == mp4Type, vs[j].ContentType == mp4Type; mi != mj {
+			return mi
+		}
+		return vs[i].Bitrate > vs[j].Bitrate
+	})
+	for _, vr := range vs {
+		v.AppendChild(&html.Node{
+			Type:     html.ElementNode,
+			DataAtom: atom.Source,
+			Data:     "source",
+			Attr:     []html.Attribute{{Key: "src", Val: vr.URL}, {Key: "type", Val: vr.ContentType}},
+		})
+	}
+}
+
+// posterLink returns an <a> element linking to href and containing a poster image.
+func posterLink(poster, href string) *html.Node {
+	link := &html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.A,
+		Data:     "a",
+		Attr:     []html.Attribute{{Key: "href", Val: href}},
+	}
+	link.AppendChild(&html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.Img,
+		Data:     "img",
+		Attr:     []html.Attribute{{Key: "alt", Val: defaultVideoAlt}, {Key: "src", Val: poster}},
+	})
+	return link
+}
+
+// defaultImageAlt and defaultVideoAlt are the alt text used for media without descriptions.
+const (
+	defaultImageAlt = "Image"
+	defaultVideoAlt = "Video"
+)
+
+// fixImageAlt copies descriptions from aria-label attributes to the alt attributes of
+// media images under n that lack alt text. Twitter labels the divs wrapping its images
+// in addition to (and sometimes instead of) the images themselves.
+func fixImageAlt(n *html.Node) {
+	for _, img := range findNodes(n, matchFunc("img")) {
+		if getAttr(img, "alt") != "" {
+			continue
+		}
+		if u, err := url.Parse(getAttr(img, "src")); err != nil || !isMediaURL(u) {
+			continue
+		}
+		for p, i := img.Parent, 0; p != nil && p != n && i < 3; p, i = p.Parent, i+1 {
+			if label := strings.TrimSpace(getAttr(p, "aria-label")); label != "" {
+				setAttr(img, "alt", label)
+				break
+			}
+		}
+	}
+}
+
+// imageDesc returns a plain-text description of img, e.g. "[image: A dog]" or "[image]" if
+// img is a media image, or "[video]" if it is a video's poster. An empty string is returned
+// for other elements and for images that aren't part of the tweet (e.g. avatars).
+func imageDesc(img *html.Node) string {
+	if !isElement(img, "img") {
+		return ""
+	}
+	pu, err := url.Parse(getAttr(img, "src"))
+	if err != nil || pu.Host != "pbs.twimg.com" || strings.HasPrefix(pu.Path, "/profile_images/") {
+		return ""
+	}
+	switch alt := strings.TrimSpace(getAttr(img, "alt")); alt {
+	case "":
+		return "" // link card thumbnails have empty alt text
+	case defaultImageAlt:
+		return "[image]"
+	case defaultVideoAlt:
+		return "[video]"
+	default:
+		return "[image: " + alt + "]"
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestNormalizeImages(t *testing.T) {
+	const (
+		media  = "https://pbs.twimg.com/media/abc?format=jpg&name=360x360"
+		avatar = "https://pbs.twimg.com/profile_images/123/abc_200x200.jpg"
+	)
+	for _, tc := range []struct {
+		orig   string
+		size   imageSize
+		srcset bool
+		want   string
+	}{
+		{`<img alt="x" src="` + media + `"/>`, "", false,
+			`<img alt="x" src="https://pbs.twimg.com/media/abc?format=jpg&amp;name=360x360"/>`},
+		{`<img alt="x" src="` + media + `"/>`, largeImage, false,
+			`<img alt="x" src="https://pbs.twimg.com/media/abc?format=jpg&amp;name=large"/>`},
+		{`<img src="` + media + `"/>`, origImage, true,
+			`<img src="https://pbs.twimg.com/media/abc?format=jpg&amp;name=orig" srcset="` +
+				`https://pbs.twimg.com/media/abc?format=jpg&amp;name=small 680w, ` +
+				`https://pbs.twimg.com/media/abc?format=jpg&amp;name=medium 1200w, ` +
+				`https://pbs.twimg.com/media/abc?format=jpg&amp;name=large 2048w"/>`},
+		{`<img src="` + avatar + `"/>`, largeImage, true, `<img src="` + avatar + `"/>`},
+		{`<div><div style="padding-bottom: 56.25%;"></div><img src="a.png"/></div>`, "", false,
+			`<div><img src="a.png"/></div>`},
+		{`<div style="padding-bottom: 10px;"></div>`, "", false, `<div style="padding-bottom: 10px;"></div>`},
+	} {
+		root, err := html.Parse(strings.NewReader(tc.orig))
+		if err != nil {
+			t.Fatalf("Failed parsing %q: %v", tc.orig, err)
+		}
+		body := findFirstNode(root, matchFunc("body"))
+		normalizeImages(body, tc.size, tc.srcset)
+
+		var b bytes.Buffer
+		if err := html.Render(&b, body.FirstChild); err != nil {
+			t.Fatal("Failed rendering tree: ", err)
+		}
+		if got := b.String(); got != tc.want {
+			t.Errorf("normalizeImages(%q, %q, %v) = %q; want %q", tc.orig, tc.size, tc.srcset, got, tc.want)
+		}
+	}
+}
+
+func TestVideoKey(t *testing.T) {
+	for _, tc := range []struct{ url, want string }{
+		{"https://pbs.twimg.com/ext_tw_video_thumb/1341135734565167104/pu/img/Db4r.jpg",
+			"ext_tw_video/1341135734565167104"},
+		{"https://video.twimg.com/ext_tw_video/1341135734565167104/pu/vid/1280x720/abc.mp4?tag=10",
+			"ext_tw_video/1341135734565167104"},
+		{"https://pbs.twimg.com/amplify_video_thumb/1339929135402119168/img/_Zv.jpg",
+			"amplify_video/1339929135402119168"},
+		{"https://pbs.twimg.com/tweet_video_thumb/EpK1.jpg", "tweet_video/EpK1"},
+		{"https://video.twimg.com/tweet_video/EpK1.mp4", "tweet_video/EpK1"},
+		{"https://example.org/ext_tw_video/123/abc.mp4", ""},
+		{"https://pbs.twimg.com/foo", ""},
+	} {
+		if got := videoKey(tc.url); got != tc.want {
+			t.Errorf("videoKey(%q) = %q; want %q", tc.url, got, tc.want)
+		}
+	}
+}
+
+func TestFindVideoVariants(t *testing.T) {
+	const data = `{"globalObjects":{"tweets":{"123":{"extended_entities":{"media":[{
+		"media_url_https": "https://pbs.twimg.com/ext_tw_video_thumb/456/pu/img/abc.jpg",
+		"video_info": {"variants": [
+			{"bitrate": 832000, "content_type": "video/mp4", "url": "https://video.twimg.com/ext_tw_video/456/pu/vid/640x360/a.mp4"},
+			{"content_type": "application/x-mpegURL", "url": "https://video.twimg.com/ext_tw_video/456/pu/pl/b.m3u8"}
+		]}
+	}, {
+		"media_url_https": "https://pbs.twimg.com/media/xyz.jpg"
+	}]}}}}}`
+	var v interface{}
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string][]videoVariant)
+	findVideoVariants(v, got)
+	want := map[string][]videoVariant{
+		"ext_tw_video/456": {
+			{URL: "https://video.twimg.com/ext_tw_video/456/pu/vid/640x360/a.mp4", ContentType: mp4Type, Bitrate: 832000},
+			{URL: "https://video.twimg.com/ext_tw_video/456/pu/pl/b.m3u8", ContentType: hlsType},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findVideoVariants() = %+v; want %+v", got, want)
+	}
+}
+
+func TestFixVideos(t *testing.T) {
+	const (
+		href   = "https://twitter.com/user/status/123"
+		poster = "https://pbs.twimg.com/ext_tw_video_thumb/456/pu/img/abc.jpg"
+		gif    = "https://pbs.twimg.com/tweet_video_thumb/EpK1.jpg"
+		mp4    = "https://video.twimg.com/ext_tw_video/456/pu/vid/640x360/a.mp4"
+		hd     = "https://video.twimg.com/ext_tw_video/456/pu/vid/1280x720/a.mp4"
+		m3u8   = "https://video.twimg.com/ext_tw_video/456/pu/pl/b.m3u8"
+	)
+	videos := map[string][]videoVariant{
+		"ext_tw_video/456": {
+			{URL: m3u8, ContentType: hlsType},
+			{URL: mp4, ContentType: mp4Type, Bitrate: 832000},
+			{URL: hd, ContentType: mp4Type, Bitrate: 2176000},
+		},
+	}
+	for _, tc := range []struct {
+		orig   string
+		videos map[string][]videoVariant
+		want   string
+	}{
+		{
+			`<div><video poster="` + poster + `" src="blob:https://twitter.com/x"></video><img src="` + poster + `"/></div>`,
+			videos,
+			`<div><video poster="` + poster + `" controls="">` +
+				`<source src="` + hd + `" type="video/mp4"/>` +
+				`<source src="` + mp4 + `" type="video/mp4"/>` +
+				`<source src="` + m3u8 + `" type="application/x-mpegURL"/></video></div>`,
+		},
+		{
+			`<div><video poster="` + poster + `" src="blob:https://twitter.com/x"></video></div>`,
+			nil,
+			`<div><a href="` + href + `"><img alt="Video" src="` + poster + `"/></a></div>`,
+		},
+		{
+			`<div><video poster="` + gif + `" src="blob:https://twitter.com/x"></video></div>`,
+			nil,
+			`<div><video poster="` + gif + `" controls="">` +
+				`<source src="https://video.twimg.com/tweet_video/EpK1.mp4" type="video/mp4"/></video></div>`,
+		},
+		{
+			`<div><video src="` + mp4 + `"></video></div>`,
+			nil,
+			`<div><video src="` + mp4 + `" controls=""></video></div>`,
+		},
+	} {
+		root, err := html.Parse(strings.NewReader(tc.orig))
+		if err != nil {
+			t.Fatalf("Failed parsing %q: %v", tc.orig, err)
+		}
+		body := findFirstNode(root, matchFunc("body"))
+		fixVideos(body, href, tc.videos)
+
+		var b bytes.Buffer
+		if err := html.Render(&b, body.FirstChild); err != nil {
+			t.Fatal("Failed rendering tree: ", err)
+		}
+		if got := b.String(); got != tc.want {
+			t.Errorf("fixVideos(%q) = %q; want %q", tc.orig, got, tc.want)
+		}
+	}
+}
+
+func TestFixImageAlt(t *testing.T) {
+	const media = "https://pbs.twimg.com/media/abc?format=jpg&amp;name=small"
+	for _, tc := range []struct{ orig, want string }{
+		{`<div aria-label="A dog"><div><img src="` + media + `"/></div></div>`,
+			`<div aria-label="A dog"><div><img src="` + media + `" alt="A dog"/></div></div>`},
+		{`<div aria-label="A dog"><img alt="A cat" src="` + media + `"/></div>`,
+			`<div aria-label="A dog"><img alt="A cat" src="` + media + `"/></div>`},
+		{`<div aria-label="A dog"><img src="https://example.org/a.png"/></div>`,
+			`<div aria-label="A dog"><img src="https://example.org/a.png"/></div>`},
+	} {
+		root, err := html.Parse(strings.NewReader(tc.orig))
+		if err != nil {
+			t.Fatalf("Failed parsing %q: %v", tc.orig, err)
+		}
+		body := findFirstNode(root, matchFunc("body"))
+		fixImageAlt(body)
+
+		var b bytes.Buffer
+		if err := html.Render(&b, body.FirstChild); err != nil {
+			t.Fatal("Failed rendering tree: ", err)
+		}
+		if got := b.String(); got != tc.want {
+			t.Errorf("fixImageAlt(%q) = %q; want %q", tc.orig, got, tc.want)
+		}
+	}
+}
+
+func TestImageDesc(t *testing.T) {
+	const src = `<div>Hi <img alt="%s" src="%s"/> there</div>`
+	for _, tc := range []struct{ alt, src, want string }{
+		{"A dog", "https://pbs.twimg.com/media/abc?name=small", "Hi [image: A dog] there"},
+		{"Image", "https://pbs.twimg.com/media/abc?name=small", "Hi [image] there"},
+		{"Video", "https://pbs.twimg.com/ext_tw_video_thumb/123/pu/img/abc.jpg", "Hi [video] there"},
+		{"", "https://pbs.twimg.com/card_img/123/abc?name=small", "Hi there"},
+		{"Me", "https://pbs.twimg.com/profile_images/123/abc_mini.jpg", "Hi there"},
+	} {
+		orig := fmt.Sprintf(src, tc.alt, tc.src)
+		root, err := html.Parse(strings.NewReader(orig))
+		if err != nil {
+			t.Fatalf("Failed parsing %q: %v", orig, err)
+		}
+		if got := getTextFunc(root, true, imageDesc); got != tc.want {
+			t.Errorf("getTextFunc(%q, true, imageDesc) = %q; want %q", orig, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// opmlDoc is an OPML 2.0 document (http://opml.org/spec2.opml).
+type opmlDoc struct {
+	XMLName  xml.Name      `xml:"opml"`
+	Version  string        `xml:"version,attr"`
+	Title    string        `xml:"head>title"`
+	Created  string        `xml:"head>dateCreated,omitempty"`
+	Outlines []opmlOutline `xml:"body>outline"`
+}
+
+// opmlOutline is an outline element within an OPML document.
+// Outlines describing feeds have type "rss".
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr,omitempty"`
+	Type     string        `xml:"type,attr,omitempty"`
+	XMLURL   string        `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string        `xml:"htmlUrl,attr,omitempty"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+// userPlaceholder is replaced by the username in feed URLs passed to writeOPML.
+const userPlaceholder = "{user}"
+
+// feedExt returns the filename extension typically used for feeds in format.
+func feedExt(format feedFormat) string {
+	switch format {
+	case jsonFormat, activityFormat:
+		return ".json"
+	default:
+		return ".xml"
+	}
+}
+
+// userFeedURL returns the URL of user's feed. If baseURL contains userPlaceholder, the
+// placeholder is replaced by user. Otherwise, user and ext are appended to baseURL.
+func userFeedURL(baseURL, user, ext string) string {
+	if strings.Contains(baseURL, userPlaceholder) {
+		return strings.ReplaceAll(baseURL, userPlaceholder, url.PathEscape(user))
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return baseURL + url.PathEscape(user) + ext
+}
+
+// writeOPML writes an OPML document to w listing feeds for the supplied users
+// (see userFeedURL for baseURL and ext).
+func writeOPML(w io.Writer, title string, users []string, baseURL, ext string) error {
+	doc := opmlDoc{
+		Version: "2.0",
+		Title:   title,
+		Created: time.Now().UTC().Format(time.RFC1123Z),
+	}
+	for _, u := range users {
+		doc.Outlines = append(doc.Outlines, opmlOutline{
+			Text:    "@" + u,
+			Title:   "@" + u,
+			Type:    "rss",
+			XMLURL:  userFeedURL(baseURL, u, ext),
+			HTMLURL: userURL(u),
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// readOPML reads an OPML document from r and returns the Twitter users whose timelines
+// are referenced by its outlines, e.g. via twitter.com or Nitter URLs.
+// If baseURL is non-empty, feed URLs under it are also recognized (see urlUser).
+func readOPML(r io.Reader, baseURL string) ([]string, error) {
+	var doc opmlDoc
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	var users []string
+	seen := make(map[string]struct{})
+	var walk func(outlines []opmlOutline)
+	walk = func(outlines []opmlOutline) {
+		for _, o := range outlines {
+			for _, u := range []string{o.HTMLURL, o.XMLURL} {
+				if user := urlUser(u, baseURL); user != "" {
+					if _, ok := seen[strings.ToLower(user)]; !ok {
+						seen[strings.ToLower(user)] = struct{}{}
+						users = append(users, user)
+					}
+					break
+				}
+			}
+			walk(o.Outlines)
+		}
+	}
+	walk(doc.Outlines)
+	return users, nil
+}
+
+var usernameRegexp = regexp.MustCompile(`^[A-Za-z0-9_]{1,15}$`)
+
+// reservedPaths contains first path components on Twitter and Nitter that aren't usernames.
+var reservedPaths = map[string]struct{}{
+	"explore": {}, "hashtag": {}, "home": {}, "i": {}, "intent": {}, "login": {},
+	"notifications": {}, "pic": {}, "search": {}, "settings": {}, "share": {},
+}
+
+// urlUser returns the user whose timeline is referenced by u, or an empty string if none is.
+// Supported URLs include "https://twitter.com/user" and "https://nitter.net/user/rss".
+// If baseURL is non-empty, feed URLs produced by userFeedURL with it (e.g. twittuh -serve URLs
+// like "http://localhost:8080/?user=user") are also supported.
+func urlUser(u, baseURL string) string {
+	if baseURL != "" {
+		if user := baseURLUser(u, baseURL); user != "" {
+			return user
+		}
+	}
+	pu, err := url.Parse(u)
+	if err != nil || pu.Host == "" {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(pu.Hostname()), "www.")
+	if host != defaultHost && host != mobileHost && host != "x.com" && !strings.Contains(host, "nitter") {
+		return ""
+	}
+	parts := strings.Split(strings.Trim(pu.Path, "/"), "/")
+	if len(parts) > 2 || (len(parts) == 2 && parts[1] != "rss") {
+		return ""
+	}
+	user := bareUser(parts[0])
+	if _, ok := reservedPaths[strings.ToLower(user)]; ok || !usernameRegexp.MatchString(user) {
+		return ""
+	}
+	return user
+}
+
+// baseURLUser returns the user whose feed is referenced by u if u was produced by
+// userFeedURL with baseURL, or an empty string otherwise.
+func baseURLUser(u, baseURL string) string {
+	var user string
+	if i := strings.Index(baseURL, userPlaceholder); i >= 0 {
+		pre, suf := baseURL[:i], baseURL[i+len(userPlaceholder):]
+		if !strings.HasPrefix(u, pre) || !strings.HasSuffix(u[len(pre):], suf) {
+			return ""
+		}
+		user = u[len(pre) : len(u)-len(suf)]
+	} else {
+		if !strings.HasSuffix(baseURL, "/") {
+			baseURL += "/"
+		}
+		if !strings.HasPrefix(u, baseURL) {
+			return ""
+		}
+		user = strings.TrimPrefix(u, baseURL)
+		user = strings.TrimSuffix(user, path.Ext(user))
+	}
+	if user, err := url.PathUnescape(user); err == nil && usernameRegexp.MatchString(user) {
+		return user
+	}
+	return ""
+}
+
+// readUsersFile reads users from p, which contains one user per line.
+// Blank lines and lines starting with '#' are ignored. If p is "-", stdin is read.
+func readUsersFile(p string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if p != "-" {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var users []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		ln := strings.TrimSpace(sc.Text())
+		if ln == "" || ln[0] == '#' {
+			continue
+		}
+		users = append(users, bareUser(ln))
+	}
+	return users, sc.Err()
+}
+
+// runOPML implements the "opml" subcommand. args excludes the subcommand itself.
+func runOPML(args []string) error {
+	fs := flag.NewFlagSet("opml", flag.ExitOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s opml export [flag]... <users> <file>\n", os.Args[0])
+		fmt.Fprintf(out, "       %s opml import <file> <users>\n", os.Args[0])
+		fmt.Fprintln(out, "Exports users as an OPML file listing their feeds, or imports users from an OPML file.")
+		fmt.Fprintln(out, "<users> is a file with one user per line or a comma-separated list of users.")
+		fmt.Fprintln(out, "Pass '-' for files to use stdin or stdout.")
+		fmt.Fprintln(out, "Flags:")
+		fs.PrintDefaults()
+	}
+	baseURL := fs.String("base-url", "", `Base URL of feeds (e.g. "https://example.org/feeds/" or "http://localhost:8080/?user=`+userPlaceholder+`")`)
+	formatFlag := fs.String("format", "atom", `Format of feeds, used for file extensions ("atom", "json", "rss", "activitystreams")`)
+	title := fs.String("title", "Twitter", "Title of exported OPML file")
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	cmd := args[0]
+	fs.Parse(args[1:])
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	src, dst := fs.Arg(0), fs.Arg(1)
+
+	var out io.Writer = os.Stdout
+	var write func(w io.Writer) error
+	switch cmd {
+	case "export":
+		if *baseURL == "" {
+			return errors.New("-base-url must be supplied")
+		}
+		var users []string
+		if _, err := os.Stat(src); err == nil || src == "-" {
+			if users, err = readUsersFile(src); err != nil {
+				return err
+			}
+		} else {
+			users = splitUsers(src)
+		}
+		if len(users) == 0 {
+			return errors.New("no users supplied")
+		}
+		write = func(w io.Writer) error {
+			return writeOPML(w, *title, users, *baseURL, feedExt(feedFormat(*formatFlag)))
+		}
+	case "import":
+		var r io.Reader = os.Stdin
+		if src != "-" {
+			f, err := os.Open(src)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		users, err := readOPML(r, *baseURL)
+		if err != nil {
+			return fmt.Errorf("failed reading %v: %v", src, err)
+		}
+		if len(users) == 0 {
+			return fmt.Errorf("no users found in %v", src)
+		}
+		write = func(w io.Writer) error {
+			for _, u := range users {
+				if _, err := fmt.Fprintln(w, u); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if dst == "-" {
+		return write(out)
+	}
+	return writeFileAtomic(dst, write)
+}
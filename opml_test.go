@@ -0,0 +1,100 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUserFeedURL(t *testing.T) {
+	for _, tc := range []struct {
+		base, user, ext, want string
+	}{
+		{"https://example.org/", "user", ".json", "https://example.org/user.json"},
+		{"https://example.org/feeds", "user", ".xml", "https://example.org/feeds/user.xml"},
+		{"http://localhost:8080/?user={user}&format=json", "user", ".json", "http://localhost:8080/?user=user&format=json"},
+	} {
+		if got := userFeedURL(tc.base, tc.user, tc.ext); got != tc.want {
+			t.Errorf("userFeedURL(%q, %q, %q) = %q; want %q", tc.base, tc.user, tc.ext, got, tc.want)
+		}
+	}
+}
+
+func TestURLUser(t *testing.T) {
+	const serveBase = "http://localhost:8080/?user={user}&format=json"
+	for _, tc := range []struct {
+		url, base, want string
+	}{
+		{"https://twitter.com/user", "", "user"},
+		{"https://mobile.twitter.com/User_1/", "", "User_1"},
+		{"https://x.com/user", "", "user"},
+		{"https://nitter.net/user/rss", "", "user"},
+		{"https://nitter.example.org/user", "", "user"},
+		{"https://twitter.com/user", serveBase, "user"},
+		{"http://localhost:8080/?user=user&format=json", serveBase, "user"},
+		{"http://localhost:8080/?user=user&format=rss", serveBase, ""},
+		{"https://example.org/feeds/user.json", "https://example.org/feeds", "user"},
+		{"https://example.org/feeds/a/user.json", "https://example.org/feeds/", ""},
+		{"https://twitter.com/user/status/123", "", ""},
+		{"https://twitter.com/search?q=foo", "", ""},
+		{"https://twitter.com/i/lists/123", "", ""},
+		{"https://twitter.com/", "", ""},
+		{"https://example.org/user", "", ""},
+		{"https://example.org/feed?user=bob", "", ""},
+		{"http://localhost:8080/?user=user&format=json", "", ""},
+		{"https://twitter.com/much_too_long_username", "", ""},
+		{"user", "", ""},
+	} {
+		if got := urlUser(tc.url, tc.base); got != tc.want {
+			t.Errorf("urlUser(%q, %q) = %q; want %q", tc.url, tc.base, got, tc.want)
+		}
+	}
+}
+
+func TestOPMLRoundTrip(t *testing.T) {
+	users := []string{"NWS", "USPS"}
+	var b bytes.Buffer
+	if err := writeOPML(&b, "Twitter", users, "https://example.org/", ".json"); err != nil {
+		t.Fatal("writeOPML failed: ", err)
+	}
+	if !strings.Contains(b.String(), `xmlUrl="https://example.org/NWS.json"`) {
+		t.Errorf("writeOPML output lacks feed URL:\n%s", b.String())
+	}
+	got, err := readOPML(&b, "")
+	if err != nil {
+		t.Fatal("readOPML failed: ", err)
+	}
+	if diff := cmp.Diff(users, got); diff != "" {
+		t.Error("Bad users after round trip:\n" + diff)
+	}
+}
+
+func TestReadOPML(t *testing.T) {
+	const doc = `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="1.0">
+  <head><title>Reader subscriptions</title></head>
+  <body>
+    <outline text="Blogs">
+      <outline text="Example" type="rss" xmlUrl="https://example.org/feed.xml" htmlUrl="https://example.org/"/>
+    </outline>
+    <outline text="Twitter">
+      <outline text="NWS" type="rss" xmlUrl="https://nitter.net/NWS/rss" htmlUrl="https://nitter.net/NWS"/>
+      <outline text="USPS" type="rss" xmlUrl="https://example.org/usps.json" htmlUrl="https://twitter.com/USPS"/>
+      <outline text="Duplicate" type="rss" xmlUrl="https://nitter.net/nws/rss"/>
+    </outline>
+  </body>
+</opml>`
+	got, err := readOPML(strings.NewReader(doc), "")
+	if err != nil {
+		t.Fatal("readOPML failed: ", err)
+	}
+	if diff := cmp.Diff([]string{"NWS", "USPS"}, got); diff != "" {
+		t.Error("Bad users:\n" + diff)
+	}
+}
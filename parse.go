@@ -9,12 +9,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"path"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
-	"unicode"
 
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
@@ -22,10 +22,19 @@ import (
 
 // profile contains information about a user.
 type profile struct {
-	User  string // screen name (without '@')
-	Name  string // full name
-	Icon  string // small (48x48) favicon URL
-	Image string // large (200x200 or 400x400) avatar URL
+	User      string    // screen name (without '@')
+	Name      string    // full name
+	Icon      string    // small (48x48) favicon URL
+	Image     string    // large (200x200 or 400x400) avatar URL
+	Banner    string    // large (1500x500) header image URL
+	Bio       string    // user-supplied description
+	Location  string    // user-supplied location
+	Website   string    // user-supplied website URL (typically a t.co link)
+	Joined    time.Time // month in which the account was created
+	Followers int64     // approximate number of followers
+	Following int64     // approximate number of followed accounts
+	Verified  bool
+	Protected bool
 }
 
 func (p *profile) displayName() string {
@@ -39,9 +48,30 @@ type tweet struct {
 	User       string // screen name (without '@')
 	Name       string // full name
 	Time       time.Time
-	Content    string   // HTML content
-	Text       string   // text from content
-	ReplyUsers []string // empty if not reply (without '@')
+	Content    string    // HTML content
+	Text       string    // text from content, including image descriptions
+	Title      string    // text for titles (image descriptions if there's no other text)
+	Lang       string    // BCP 47 language code from Twitter, e.g. "en" or "und" if undetermined
+	ReplyUsers []string  // empty if not reply (without '@')
+	ReplyTo    int64     // ID of replied-to tweet if linked from "Replying to", or 0 if unknown
+	Pinned     bool      // true if pinned to the top of the timeline
+	Hashtags   []string  // hashtags in text (without '#')
+	Mentions   []string  // users mentioned in text (without '@')
+	Cashtags   []string  // cashtags in text (without '$')
+	URLs       []string  // outbound URLs linked from text
+	Card       *linkCard // link card, if any
+	Truncated  bool      // true if Twitter cut off the text with "Show more" (see fetchOptions.expandTweets)
+	Retweet    bool      // true if retweeted onto the timeline by another user
+	Quote      bool      // true if the tweet quotes another tweet
+	Media      bool      // true if the tweet (or its embed) contains images or videos
+	Replies    int64     // approximate number of replies
+	Retweets   int64     // approximate number of retweets
+	Likes      int64     // approximate number of likes
+	Labels     []string  // labels attached by Twitter, e.g. sensitiveLabel
+	Note       string    // text of Community Note ("Readers added context"), if any
+
+	Thread  []int64   // IDs of later self-replies merged by mergeThreads
+	Updated time.Time // time of last merged self-reply
 }
 
 func (t *tweet) displayName() string {
@@ -54,52 +84,154 @@ func (t *tweet) reply() bool {
 	return len(t.ReplyUsers) > 0 && (len(t.ReplyUsers) > 1 || t.ReplyUsers[0] != t.User)
 }
 
+// undeterminedLang is the language code that Twitter uses for tweets whose language
+// couldn't be determined (e.g. ones only containing links or emoji).
+const undeterminedLang = "und"
+
+// hasLang returns true if t should be included given the supplied lists of languages to
+// include (ignored if empty) and skip. Languages are matched case-insensitively by their
+// primary subtags, e.g. "en" matches "en-GB". Tweets with unknown or undetermined languages
+// aren't excluded by langs, but they can be skipped by including "und" in skipLangs.
+func (t *tweet) hasLang(langs, skipLangs []string) bool {
+	lang := t.Lang
+	if lang == "" {
+		lang = undeterminedLang
+	}
+	match := func(l string) bool {
+		l = strings.TrimSpace(l)
+		return strings.EqualFold(l, lang) || strings.EqualFold(l, primaryLang(lang))
+	}
+	for _, l := range skipLangs {
+		if match(l) {
+			return false
+		}
+	}
+	if len(langs) == 0 || lang == undeterminedLang {
+		return true
+	}
+	for _, l := range langs {
+		if match(l) {
+			return true
+		}
+	}
+	return false
+}
+
+// primaryLang returns the primary subtag from a BCP 47 language tag, e.g. "en" for "en-GB".
+func primaryLang(lang string) string {
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		return lang[:i]
+	}
+	return lang
+}
+
 type parseOptions struct {
-	simplify bool
+	simplify    bool
+	expandLinks bool          // rewrite t.co links to point at their destinations
+	resolver    *linkResolver // used by expandLinks if non-nil
+	imageSize   imageSize     // size for tweet images; empty to leave unchanged
+	imageSrcset bool          // add srcset attributes to tweet images
+	sanitize    bool          // strip all but allowlisted elements and attributes from content
+
+	// maxSkipRatio is the maximum fraction of unparsable tweets that can be skipped
+	// by parseTimeline without returning an error.
+	maxSkipRatio float64
 }
 
 // parseTimeline reads an HTML document containing a Twitter timeline from r and returns its tweets.
-func parseTimeline(r io.Reader, opts parseOptions) (profile, []tweet, error) {
+// If user is non-empty, an error is returned if the timeline belongs to a different user.
+// Tweets that can't be parsed are skipped and described by the returned warnings, but an error
+// is returned if the fraction of skipped tweets exceeds opts.maxSkipRatio.
+func parseTimeline(r io.Reader, user string, opts parseOptions) (profile, []tweet, []parseWarning, error) {
 	var prof profile
 	root, err := html.Parse(r)
 	if err != nil {
-		return prof, nil, err
+		return prof, nil, nil, err
 	}
-	col := findFirstNode(root, matchFunc("div", "data-testid=primaryColumn"))
+	col := findFirstNode(root, rules.PrimaryColumn.match)
 	if col == nil {
-		return prof, nil, errors.New("didn't find primary column")
+		return prof, nil, nil, errors.New("didn't find primary column")
 	}
 
+	// Emoji are represented by images (usually wrapped in divs), so replace all that
+	// garbage with text nodes containing the actual emoji before reading any text.
+	normalizeEmoji(col)
+
 	if prof, err = parseProfile(col); err != nil {
-		return prof, nil, fmt.Errorf("failed parsing profile: %v", err)
+		return prof, nil, nil, fmt.Errorf("failed parsing profile: %v", err)
+	}
+	if user != "" && !strings.EqualFold(prof.User, bareUser(user)) {
+		return prof, nil, nil, fmt.Errorf("got timeline for %q instead of %q", prof.User, user)
+	}
+	if prof.Website != "" && opts.expandLinks && opts.resolver != nil {
+		if dst, err := opts.resolver.resolve(prof.Website); err != nil {
+			debugf("Failed resolving %v: %v", prof.Website, err)
+		} else {
+			prof.Website = dst
+		}
 	}
 
+	videos := readVideoVariants(root)
+
 	var tweets []tweet
-	for i, tn := range findNodes(col, matchFunc("div", "data-testid=tweet")) {
-		tw, err := parseTweet(tn, prof.User, opts)
+	var warnings []parseWarning
+	tns := findNodes(col, rules.Tweet.match)
+	for i, tn := range tns {
+		tw, err := parseTweet(tn, prof.User, videos, opts)
 		if err != nil {
-			var id string
-			if tw.ID > 0 {
-				id = fmt.Sprintf("%d", tw.ID)
-			} else {
-				id = fmt.Sprintf("at index %d", i)
-			}
-			return prof, nil, fmt.Errorf("failed parsing tweet %s: %v", id, err)
+			warnings = append(warnings, parseWarning{Index: i, ID: tw.ID, Reason: err.Error()})
+			continue
 		}
 		tweets = append(tweets, tw)
 	}
 
-	return prof, tweets, nil
+	if len(warnings) > 0 && float64(len(warnings)) > opts.maxSkipRatio*float64(len(tns)) {
+		return prof, nil, warnings, fmt.Errorf("failed parsing %d of %d tweet(s) (first: %v)",
+			len(warnings), len(tns), warnings[0])
+	}
+	return prof, tweets, warnings, nil
+}
+
+// parseWarning describes a tweet that was skipped by parseTimeline.
+type parseWarning struct {
+	Index  int    // index of tweet within timeline
+	ID     int64  // tweet ID, or 0 if unknown
+	Reason string // reason that tweet was skipped
+}
+
+func (w parseWarning) String() string {
+	if w.ID > 0 {
+		return fmt.Sprintf("tweet %d: %v", w.ID, w.Reason)
+	}
+	return fmt.Sprintf("tweet at index %d: %v", w.Index, w.Reason)
 }
 
 // Matches the size suffix on the end of a profile image, e.g. "_200x200.jpg".
 var imgSizeRegexp = regexp.MustCompile(`_\d+x\d+\.jpg$`)
 
+// Matches the size suffix on the end of a banner image, e.g. "/600x200".
+var bannerSizeRegexp = regexp.MustCompile(`/\d+x\d+$`)
+
+// replyToID returns the ID of the tweet linked from n, a "Replying to" div, or 0 if
+// the replied-to tweet isn't linked.
+func replyToID(n *html.Node) int64 {
+	for _, a := range findNodes(n, matchFunc("a", "href")) {
+		if m := statusPathRegexp.FindStringSubmatch(getAttr(a, "href")); m != nil {
+			if id, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+				return id
+			}
+		}
+	}
+	return 0
+}
+
+// statusPathRegexp matches links to tweets, e.g. "/user/status/123", capturing the ID.
+var statusPathRegexp = regexp.MustCompile(`^(?:https://(?:mobile\.)?twitter\.com)?/[A-Za-z0-9_]+/status/(\d+)$`)
+
 // parseProfile parses profile data from the supplied primary column from a timeline page.
 func parseProfile(n *html.Node) (profile, error) {
 	var pr profile
 
-	// TODO: Should check that the username matches, but we don't pass it in.
 	un := findFirstNode(n, func(n *html.Node) bool {
 		return isText(n) && len(n.Data) > 1 && n.Data[0] == '@'
 	})
@@ -111,7 +243,12 @@ func parseProfile(n *html.Node) (profile, error) {
 	if un.Parent == nil || un.Parent.Parent == nil || un.Parent.Parent.Parent == nil {
 		return pr, errors.New("didn't find full name")
 	}
-	pr.Name = getText(un.Parent.Parent.Parent.PrevSibling, false)
+	nn := un.Parent.Parent.Parent.PrevSibling
+	pr.Name = getText(nn, false)
+	if nn != nil {
+		pr.Verified = findFirstNode(nn, matchFunc("svg", "aria-label=Verified account")) != nil
+		pr.Protected = findFirstNode(nn, matchFunc("svg", "aria-label=Protected account")) != nil
+	}
 
 	img := findFirstNode(n, func(n *html.Node) bool {
 		return isElement(n, "img") && strings.Contains(getAttr(n, "src"), "/profile_images/")
@@ -122,21 +259,99 @@ func parseProfile(n *html.Node) (profile, error) {
 	pr.Image = imgSizeRegexp.ReplaceAllLiteralString(getAttr(img, "src"), "_400x400.jpg")
 	pr.Icon = imgSizeRegexp.ReplaceAllLiteralString(pr.Image, "_normal.jpg")
 
+	// Everything else is optional.
+	if img := findFirstNode(n, func(n *html.Node) bool {
+		return isElement(n, "img") && strings.Contains(getAttr(n, "src"), "/profile_banners/")
+	}); img != nil {
+		pr.Banner = bannerSizeRegexp.ReplaceAllLiteralString(getAttr(img, "src"), "/1500x500")
+	}
+	if desc := findFirstNode(n, rules.UserDescription.match); desc != nil {
+		pr.Bio = strings.TrimSpace(getText(desc, false))
+	}
+	if items := findFirstNode(n, rules.UserItems.match); items != nil {
+		parseProfileItems(items, &pr)
+	}
+	for _, c := range []struct {
+		dst  *int64
+		path string
+	}{
+		{&pr.Following, "following"},
+		{&pr.Followers, "followers"},
+	} {
+		// The link contains spans with the count (e.g. "64.6K") and a label.
+		link := findFirstNode(n, matchFunc("a", "href=/"+pr.User+"/"+c.path))
+		if link == nil {
+			continue
+		}
+		if tn := findFirstNode(link, isText); tn != nil {
+			var err error
+			if *c.dst, err = parseCount(tn.Data); err != nil {
+				debugf("Failed parsing %v count: %v", c.path, err)
+			}
+		}
+	}
+
 	return pr, nil
 }
 
+// parseProfileItems parses the location, website, and join date from n,
+// the "UserProfileHeader_Items" div in a profile, into pr.
+func parseProfileItems(n *html.Node, pr *profile) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if isElement(c, "a") {
+			pr.Website = getAttr(c, "href")
+			continue
+		}
+		if !isElement(c, "span") {
+			continue
+		}
+		text := cleanText(getText(c, false))
+		switch {
+		case strings.HasPrefix(text, "Joined "):
+			if t, err := time.Parse("January 2006", text[len("Joined "):]); err == nil {
+				pr.Joined = t
+			} else {
+				debugf("Failed parsing join date %q: %v", text, err)
+			}
+		case strings.HasPrefix(text, "Born "):
+			// Birthdays usually lack years, so there's not much point in parsing them.
+		case pr.Location == "":
+			pr.Location = text
+		}
+	}
+}
+
+// parseCount parses an abbreviated count like "315", "2,536", "64.6K", or "3M".
+func parseCount(s string) (int64, error) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+	mult := 1.0
+	if strings.HasSuffix(s, "K") {
+		mult = 1e3
+	} else if strings.HasSuffix(s, "M") {
+		mult = 1e6
+	} else if strings.HasSuffix(s, "B") {
+		mult = 1e9
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v*mult + 0.5), nil
+}
+
 // parseTweet parses a single tweet from the supplied tweet div.
-func parseTweet(n *html.Node, timelineUser string, opts parseOptions) (tweet, error) {
+// videos contains video variants saved by fetchTimeline (see readVideoVariants).
+func parseTweet(n *html.Node, timelineUser string, videos map[string][]videoVariant,
+	opts parseOptions) (tweet, error) {
 	var tw tweet
 	if n.FirstChild == nil || n.FirstChild.NextSibling == nil {
 		return tw, errors.New("no right column")
 	}
 	main := n.FirstChild.NextSibling // first child is left column with profile photo
 
-	// Emoji are (usually?) represented by divs containing img tags, so replace all
-	// that garbage with text nodes containing the actual emoji.
-	fixEmoji(main)
-
 	head := main.FirstChild
 	if head == nil {
 		return tw, errors.New("no header")
@@ -182,11 +397,17 @@ func parseTweet(n *html.Node, timelineUser string, opts parseOptions) (tweet, er
 	}
 	tw.Name = getText(un.Parent.Parent.Parent.PrevSibling, false)
 
+	tw.Pinned = isPinned(n)
+
 	body := head.NextSibling
 	if body == nil {
 		return tw, errors.New("no body")
 	}
 
+	// Long tweets that fetchTimeline couldn't expand contain a "Show more" control.
+	tw.Truncated = removeShowMore(body)
+	extractLabels(n, body, &tw)
+
 	// Within the body, there's:
 	// - an optional div containing "Replying to ..."
 	// - a div containing the tweet text (possibly empty)
@@ -208,16 +429,26 @@ func parseTweet(n *html.Node, timelineUser string, opts parseOptions) (tweet, er
 		}) {
 			tw.ReplyUsers = append(tw.ReplyUsers, n.Data[1:])
 		}
+		tw.ReplyTo = replyToID(children[0])
 		text = children[1]
 		embed = children[2]
 	default:
 		return tw, fmt.Errorf("body contains %d children; want 3 or 4", len(children))
 	}
 
+	extractEntities(text, &tw)
+	parseEngagement(children[len(children)-1], &tw)
+
+	// The div containing the tweet's text is annotated with its language.
+	if ln := findFirstNode(text, matchFunc("div", "lang")); ln != nil {
+		tw.Lang = getAttr(ln, "lang")
+	}
+
 	content := &html.Node{Type: html.ElementNode, DataAtom: atom.Div, Data: "div"}
+	var cardLink *html.Node // link in rendered link card
 
 	// If this is a retweet, add an attribution link at the top.
-	if tw.User != timelineUser {
+	if tw.Retweet = tw.User != timelineUser; tw.Retweet {
 		link := &html.Node{
 			Type:     html.ElementNode,
 			DataAtom: atom.A,
@@ -238,12 +469,22 @@ func parseTweet(n *html.Node, timelineUser string, opts parseOptions) (tweet, er
 		content.AppendChild(&html.Node{Type: html.ElementNode, DataAtom: atom.Hr, Data: "hr"})
 		content.AppendChild(&html.Node{Type: html.ElementNode, DataAtom: atom.Br, Data: "br"})
 		body.RemoveChild(embed)
-		improveQuoteTweetHeader(embed)
-		improveLinkCard(embed)
-		content.AppendChild(embed)
+		tw.Quote = improveQuoteTweetHeader(embed)
+		tw.Card, cardLink = improveLinkCard(embed)
+		if tw.Quote {
+			// Put quoted tweets in blockquotes so they can also be rendered as quotes in
+			// other formats (see renderContent).
+			bq := &html.Node{Type: html.ElementNode, DataAtom: atom.Blockquote, Data: "blockquote"}
+			bq.AppendChild(embed)
+			content.AppendChild(bq)
+		} else {
+			content.AppendChild(embed)
+		}
 	}
 
-	fixVideos(content)
+	fixVideos(content, tw.Href, videos)
+	fixImageAlt(content)
+	normalizeImages(content, opts.imageSize, opts.imageSrcset)
 	rewriteRelativeLinks(content)
 	inlineUserLinks(content)
 	addLineBreaks(content)
@@ -254,56 +495,92 @@ func parseTweet(n *html.Node, timelineUser string, opts parseOptions) (tweet, er
 		simplifyContent(content)
 		linkifyURLs(content) // requires simplifyContent to merge spans first
 	}
+	if opts.expandLinks {
+		expandLinks(content, opts.resolver)
+		if cardLink != nil {
+			tw.Card.URL = getAttr(cardLink, "href")
+		}
+	}
+	// Sanitize last so that nothing unsafe can be introduced by the earlier passes.
+	if opts.sanitize {
+		sanitizeContent(content)
+	}
+
+	tw.Text = getTextFunc(content, true, imageDesc)
+	tw.Media = findFirstNode(content, func(n *html.Node) bool {
+		return isElement(n, "video") || imageDesc(n) != ""
+	}) != nil
+	if tw.Title = getText(content, true); tw.Title == "" {
+		var descs []string
+		for _, img := range findNodes(content, matchFunc("img")) {
+			if d := imageDesc(img); d != "" {
+				descs = append(descs, d)
+			}
+		}
+		tw.Title = strings.Join(descs, " ")
+	}
 
+	// Add labels after getting the text so they don't end up in titles.
+	if lb := tw.labelBlock(); lb != nil {
+		content.AppendChild(lb)
+	}
 	var b bytes.Buffer
 	if err := html.Render(&b, content); err != nil {
 		return tw, fmt.Errorf("failed rendering text: %v", err)
 	}
 	tw.Content = b.String()
-	tw.Text = getText(content, true)
 
 	return tw, nil
 }
 
-// Used by fixEmoji to extract code point from e.g.
-// "https://abs-0.twimg.com/emoji/v2/svg/1f449.svg" or
-// "https://abs-0.twimg.com/emoji/v2/svg/1f4aa-1f3fe.svg".
-var emojiRegexp = regexp.MustCompile(`^https://.*/emoji/v2/svg/([-0-9a-f]+)\.svg$`)
+// isPinned returns true if n, a tweet div, is labeled as the user's pinned tweet.
+func isPinned(n *html.Node) bool {
+	// The "Pinned Tweet" social context lives in a separate branch of the tweet's enclosing
+	// <article> element, i.e. it isn't under n. Retweets have a "<name> Retweeted" context
+	// in the same location.
+	art := tweetArticle(n)
+	if art == nil {
+		return false
+	}
+	sc := findFirstNode(art, rules.SocialContext.match)
+	return sc != nil && getText(sc, true) == rules.PinnedContext
+}
 
-// fixEmoji emoji images with text nodes containing the emoji themselves.
-func fixEmoji(root *html.Node) {
-	// Emoji are placed within divs for no good reason as far as I can tell. We need
-	// to replace the outer divs so that we don't start a new block in the HTML.
-Loop:
-	for _, n := range findNodes(root, func(n *html.Node) bool {
-		return isElement(n, "div") && getAttr(n, "style") == "height: 1.2em;" && getAttr(n, "aria-label") != ""
-	}) {
-		img := findFirstNode(n, func(n *html.Node) bool {
-			return isElement(n, "img") && emojiRegexp.MatchString(getAttr(n, "src"))
-		})
-		if img == nil {
+// tweetArticle returns the <article> element enclosing n, a tweet div, or nil if there isn't one.
+func tweetArticle(n *html.Node) *html.Node {
+	art := n.Parent
+	for art != nil && !isElement(art, "article") {
+		art = art.Parent
+	}
+	return art
+}
+
+// extractEntities sets tw's hashtags, mentions, cashtags, and URLs from links in n,
+// the tweet's text. n's links are expected to still be relative.
+func extractEntities(n *html.Node, tw *tweet) {
+	for _, link := range findNodes(n, matchFunc("a", "href")) {
+		text := getText(link, false)
+		u, err := url.Parse(getAttr(link, "href"))
+		if err != nil || text == "" {
 			continue
 		}
-
-		// The URL gives us the individual code points in the emoji (including 200d for ZWJ),
-		// so split it on dashes and then reassemble the runes.
-		var data string
-		src := getAttr(img, "src")
-		for _, s := range strings.Split(emojiRegexp.FindStringSubmatch(src)[1], "-") {
-			v, err := strconv.ParseUint(s, 16, 64)
-			if err != nil {
-				debugf("Failed getting code point from %q: %v", src, err)
-				continue Loop
-			}
-			if v > unicode.MaxRune {
-				debugf("Invalid code point U+%X in %q", v, err)
-				continue Loop
+		switch {
+		case text[0] == '#' && strings.HasPrefix(u.Path, "/hashtag/"):
+			tw.Hashtags = appendUnique(tw.Hashtags, text[1:])
+		case text[0] == '$' && u.Query().Get("src") == "cashtag_click":
+			tw.Cashtags = appendUnique(tw.Cashtags, text[1:])
+		case text[0] == '@' && !u.IsAbs():
+			tw.Mentions = appendUnique(tw.Mentions, text[1:])
+		case u.IsAbs() && u.Host != defaultHost && u.Host != mobileHost:
+			// Outbound links point at t.co, but Twitter puts the full destination URL
+			// in the link text (hiding the scheme and the part following the ellipsis).
+			if s := strings.TrimRight(text, "…"); strings.HasPrefix(s, "https://") ||
+				strings.HasPrefix(s, "http://") {
+				tw.URLs = appendUnique(tw.URLs, s)
+			} else {
+				tw.URLs = appendUnique(tw.URLs, u.String())
 			}
-			data += string(rune(v))
 		}
-
-		// Replace the outer div with a text node containing the actual emoji.
-		replaceNode(&html.Node{Type: html.TextNode, Data: data}, n)
 	}
 }
 
@@ -362,20 +639,77 @@ func inlineUserLinks(n *html.Node) {
 	}
 }
 
-// improveQuoteTweetHeader looks for a quoted tweet header in n, an embed.
-// If it finds one, it replaces it with a single text node containing its text contents.
-func improveQuoteTweetHeader(n *html.Node) {
+// parseEngagement sets tw's reply, retweet, and like counts from n,
+// the div at the bottom of the tweet containing buttons for each action.
+func parseEngagement(n *html.Node, tw *tweet) {
+	for _, c := range []struct {
+		dst *int64
+		sel selector
+	}{
+		{&tw.Replies, rules.ReplyButton},
+		{&tw.Retweets, rules.RetweetButton},
+		{&tw.Likes, rules.LikeButton},
+	} {
+		// The button contains a span with the count (e.g. "64.6K"), or no text if the count is 0.
+		btn := findFirstNode(n, c.sel.match)
+		if btn == nil {
+			continue
+		}
+		if s := strings.TrimSpace(getText(btn, false)); s != "" {
+			var err error
+			if *c.dst, err = parseCount(s); err != nil {
+				debugf("Failed parsing count for %v: %v", tw.ID, err)
+			}
+		}
+	}
+}
+
+// removeShowMore removes "Show more" controls (see rules.ShowMore) from truncated tweets
+// under n, along with any of their ancestors that are left empty. It returns true if
+// any controls were found.
+func removeShowMore(n *html.Node) bool {
+	ctrls := findNodes(n, rules.ShowMore.match)
+	for _, c := range ctrls {
+		removeWithEmptyAncestors(c, n)
+	}
+	return len(ctrls) > 0
+}
+
+// removeWithEmptyAncestors removes n from the tree, along with any of its ancestors
+// below stop that would be left empty. Nothing is done if n was already removed.
+func removeWithEmptyAncestors(n, stop *html.Node) {
+	for n.Parent != nil && n.Parent != stop && n.PrevSibling == nil && n.NextSibling == nil {
+		n = n.Parent
+	}
+	if n.Parent != nil {
+		n.Parent.RemoveChild(n)
+	}
+}
+
+// quoteTweetHeader returns the div containing a quoted tweet's header under n,
+// or nil if n doesn't contain a quoted tweet.
+func quoteTweetHeader(n *html.Node) *html.Node {
 	// Look for a timestamp to try to identify a quoted tweet header.
 	tn := findFirstNode(n, matchFunc("time"))
 	if tn == nil || !isElement(tn.Parent, "span") || !isElement(tn.Parent.Parent, "div") ||
 		!isElement(tn.Parent.Parent.Parent, "div") {
-		return
+		return nil
+	}
+	return tn.Parent.Parent.Parent
+}
+
+// improveQuoteTweetHeader looks for a quoted tweet header in n, an embed.
+// If it finds one, it replaces it with a single text node containing its text contents
+// and returns true.
+func improveQuoteTweetHeader(n *html.Node) bool {
+	div := quoteTweetHeader(n)
+	if div == nil {
+		return false
 	}
 
 	// It looks like Twitter doesn't give us the link to the quoted tweet, unfortunately.
 	// Just merge all the text so it isn't spread across multiple divs. Prepend a space so
 	// the it won't be flush against the profile image -- Feedly strips most (all?) styling.
-	div := tn.Parent.Parent.Parent
 	s := " " + getText(div, true)
 
 	// Find the profile image and detach it so we can add it later.
@@ -403,61 +737,38 @@ func improveQuoteTweetHeader(n *html.Node) {
 	}) {
 		n.Data = ""
 	}
-}
-
-// improveLinkCard looks for a link card in n and improves its styling.
-func improveLinkCard(n *html.Node) {
-	cn := findFirstNode(n, func(n *html.Node) bool {
-		if !isElement(n, "div") {
-			return false
-		}
-		id := getAttr(n, "data-testid")
-		return id == "card.layoutSmall.detail" || id == "card.layoutLarge.detail"
-	})
-	if cn == nil {
-		return
-	}
-
-	// Cards appear to contain three div children: a title, description, and domain.
-	var children []*html.Node
-	for n := cn.FirstChild; n != nil; n = n.NextSibling {
-		children = append(children, n)
-	}
-	if len(children) != 3 {
-		return
-	}
-
-	// Reparent the title text under a <b> element.
-	if title := findFirstNode(children[0], isText); title != nil {
-		bold := &html.Node{Type: html.ElementNode, DataAtom: atom.B, Data: "b"}
-		title.Parent.InsertBefore(bold, title)
-		title.Parent.RemoveChild(title)
-		bold.AppendChild(title)
-	}
-
-	// Reparent the domain under an <i> element.
-	if domain := findFirstNode(children[2], isText); domain != nil {
-		italic := &html.Node{Type: html.ElementNode, DataAtom: atom.I, Data: "i"}
-		domain.Parent.InsertBefore(italic, domain)
-		domain.Parent.RemoveChild(domain)
-		italic.AppendChild(domain)
-	}
+	return true
 }
 
 // fixVideos tries to improve <video> elements under n, an embed.
-// The "controls" attribute is added to playable (i.e. non-blob) elements,
-// and <img> tags containing screenshots are removed.
-func fixVideos(n *html.Node) {
+// <img> tags containing screenshots are removed. Blob sources (used by Twitter's MSE-based
+// player) are replaced by <source> elements listing the video's variants from videos,
+// or if no variants are known, the video is replaced by its poster image linked to href.
+// The "controls" attribute is added to playable elements.
+func fixVideos(n *html.Node, href string, videos map[string][]videoVariant) {
 	for _, v := range findNodes(n, matchFunc("video")) {
-		src := getAttr(v, "src")
-		if !strings.HasPrefix(src, "blob:") {
-			v.Attr = append(v.Attr, html.Attribute{Key: "controls"})
-		}
-		if poster := getAttr(v, "poster"); poster != "" {
+		poster := getAttr(v, "poster")
+		if poster != "" {
 			for _, img := range findNodes(n, matchFunc("img", "src="+poster)) {
 				img.Parent.RemoveChild(img)
 			}
 		}
+
+		if src := getAttr(v, "src"); strings.HasPrefix(src, "blob:") {
+			variants := videos[videoKey(poster)]
+			if len(variants) == 0 {
+				variants = gifVariants(poster)
+			}
+			if len(variants) == 0 {
+				if poster != "" {
+					replaceNode(posterLink(poster, href), v)
+				}
+				continue
+			}
+			deleteAttr(v, "src")
+			addVideoSources(v, variants)
+		}
+		v.Attr = append(v.Attr, html.Attribute{Key: "controls"})
 	}
 }
 
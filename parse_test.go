@@ -7,6 +7,7 @@ package main
 import (
 	"bytes"
 	"flag"
+	"fmt"
 	"html/template"
 	"io/ioutil"
 	"os"
@@ -29,71 +30,127 @@ func TestParseTimeline(t *testing.T) {
 	if err != nil {
 		t.Fatal("Failed globbing HTML files: ", err)
 	}
-	for _, fn := range fns {
-		if strings.HasSuffix(fn, "-golden.html") {
-			continue
-		}
-
-		df, err := os.Open(fn)
-		if err != nil {
-			t.Fatal("Failed opening HTML file: ", err)
-		}
-		defer df.Close()
-
-		prof, tweets, err := parseTimeline(df, parseOptions{simplify: true})
-		if err != nil {
-			t.Errorf("Failed parsing %v: %v", fn, err)
-			continue
-		}
+	// Each set of options is checked against its own golden files.
+	for _, tc := range []struct {
+		suffix string // inserted before "-golden.html" in golden file names
+		opts   parseOptions
+	}{
+		{"", parseOptions{simplify: true}},
+		{"-expand-links", parseOptions{simplify: true, expandLinks: true}},
+		{"-sanitize", parseOptions{simplify: true, sanitize: true}},
+	} {
+		for _, fn := range fns {
+			if strings.HasSuffix(fn, "-golden.html") {
+				continue
+			}
 
-		// Write the parsed profile and tweets as a simple HTML document.
-		var out bytes.Buffer
-		tmpl := template.Must(template.New("").Funcs(map[string]interface{}{
-			"Raw":  func(s string) template.HTML { return template.HTML(s) },
-			"Time": func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04:05") },
-		}).Parse(tweetsTmpl))
-		if err := tmpl.Execute(&out, struct {
-			Profile profile
-			Tweets  []tweet
-		}{prof, tweets}); err != nil {
-			t.Fatal("Failed executing template: ", err)
-		}
+			df, err := os.Open(fn)
+			if err != nil {
+				t.Fatal("Failed opening HTML file: ", err)
+			}
+			defer df.Close()
 
-		// Pretty-print the HTML to make it easier to compare.
-		root, err := html.Parse(&out)
-		if err != nil {
-			t.Fatalf("Output for %v is malformed: %v", fn, err)
-		}
-		out.Reset()
-		if err := htmlpretty.Print(&out, root, "  ", 120); err != nil {
-			t.Fatalf("Failed pretty-printing output for %v: %v", fn, err)
-		}
+			// Files are named e.g. "NWS-20201231.html".
+			user := filepath.Base(fn)
+			user = user[:strings.IndexByte(user, '-')]
+			prof, tweets, warnings, err := parseTimeline(df, user, tc.opts)
+			if err != nil {
+				t.Errorf("Failed parsing %v with %+v: %v", fn, tc.opts, err)
+				continue
+			}
+			for _, w := range warnings {
+				t.Errorf("Got warning for %v: %v", fn, w)
+			}
 
-		gfn := fn[:len(fn)-5] + "-golden.html"
-		if *updateGolden {
-			if err := ioutil.WriteFile(gfn, out.Bytes(), 0644); err != nil {
-				t.Fatal("Failed writing golden file: ", err)
+			// Write the parsed profile and tweets as a simple HTML document.
+			var out bytes.Buffer
+			tmpl := template.Must(template.New("").Funcs(map[string]interface{}{
+				"Raw":  func(s string) template.HTML { return template.HTML(s) },
+				"Time": func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04:05") },
+			}).Parse(tweetsTmpl))
+			if err := tmpl.Execute(&out, struct {
+				Profile profile
+				Tweets  []tweet
+			}{prof, tweets}); err != nil {
+				t.Fatal("Failed executing template: ", err)
 			}
-		} else {
-			golden, err := ioutil.ReadFile(gfn)
+
+			// Pretty-print the HTML to make it easier to compare.
+			root, err := html.Parse(&out)
 			if err != nil {
-				t.Fatal("Failed reading golden file: ", err)
+				t.Fatalf("Output for %v is malformed: %v", fn, err)
 			}
-			if diff := cmp.Diff(string(golden), out.String()); diff != "" {
-				tf, err := ioutil.TempFile("", "twittuh.parse_test.*.html")
+			out.Reset()
+			if err := htmlpretty.Print(&out, root, "  ", 120); err != nil {
+				t.Fatalf("Failed pretty-printing output for %v: %v", fn, err)
+			}
+
+			gfn := fn[:len(fn)-5] + tc.suffix + "-golden.html"
+			if *updateGolden {
+				if err := ioutil.WriteFile(gfn, out.Bytes(), 0644); err != nil {
+					t.Fatal("Failed writing golden file: ", err)
+				}
+			} else {
+				golden, err := ioutil.ReadFile(gfn)
 				if err != nil {
-					t.Fatal("Failed creating temp file: ", err)
+					t.Fatal("Failed reading golden file: ", err)
 				}
-				defer tf.Close()
-				if _, err := tf.Write(out.Bytes()); err != nil {
-					t.Fatalf("Failed writing %v: %v", tf.Name(), err)
+				if diff := cmp.Diff(string(golden), out.String()); diff != "" {
+					tf, err := ioutil.TempFile("", "twittuh.parse_test.*.html")
+					if err != nil {
+						t.Fatal("Failed creating temp file: ", err)
+					}
+					defer tf.Close()
+					if _, err := tf.Write(out.Bytes()); err != nil {
+						t.Fatalf("Failed writing %v: %v", tf.Name(), err)
+					}
+					t.Errorf("Didn't get expected output for %v with %+v:\n%v\n\nSee %v", fn, tc.opts, diff, tf.Name())
 				}
-				t.Errorf("Didn't get expected output for %v:\n%v\n\nSee %v", fn, diff, tf.Name())
 			}
 		}
 	}
 }
 
+func TestParseTimelineSkipBadTweets(t *testing.T) {
+	const fn = "testdata/NWS-20201231.html"
+	f, err := os.Open(fn)
+	if err != nil {
+		t.Fatal("Failed opening HTML file: ", err)
+	}
+	defer f.Close()
+	root, err := html.Parse(f)
+	if err != nil {
+		t.Fatalf("Failed parsing %v: %v", fn, err)
+	}
+
+	// Break the second tweet by removing its right column.
+	tns := findNodes(root, rules.Tweet.match)
+	if len(tns) < 2 {
+		t.Fatalf("Found %d tweet(s) in %v; want at least 2", len(tns), fn)
+	}
+	tns[1].RemoveChild(tns[1].LastChild)
+	var b bytes.Buffer
+	if err := html.Render(&b, root); err != nil {
+		t.Fatal("Failed rendering tree: ", err)
+	}
+
+	_, tweets, warnings, err := parseTimeline(bytes.NewReader(b.Bytes()), "NWS", parseOptions{maxSkipRatio: 0.5})
+	if err != nil {
+		t.Fatal("parseTimeline failed: ", err)
+	}
+	if len(tweets) != len(tns)-1 {
+		t.Errorf("parseTimeline returned %d tweet(s); want %d", len(tweets), len(tns)-1)
+	}
+	if len(warnings) != 1 || warnings[0].Index != 1 {
+		t.Errorf("parseTimeline returned warnings %v; want one for index 1", warnings)
+	}
+
+	// With no tolerance for failures, the whole timeline should be rejected.
+	if _, _, _, err := parseTimeline(bytes.NewReader(b.Bytes()), "NWS", parseOptions{}); err == nil {
+		t.Error("parseTimeline unexpectedly succeeded with maxSkipRatio 0")
+	}
+}
+
 func TestAddLineBreaks(t *testing.T) {
 	for _, tc := range []struct {
 		orig, want string
@@ -133,6 +190,84 @@ func TestAddLineBreaks(t *testing.T) {
 	}
 }
 
+func TestRemoveShowMore(t *testing.T) {
+	const ctrl = `<a href="/user/status/1" data-testid="tweet-text-show-more-link"><span>Show more</span></a>`
+	for _, tc := range []struct {
+		orig, want string
+		found      bool
+	}{
+		{`<div><div lang="en">Short</div></div>`, `<div><div lang="en">Short</div></div>`, false},
+		{`<div><div lang="en">Long…</div><div><div>` + ctrl + `</div></div></div>`,
+			`<div><div lang="en">Long…</div></div>`, true},
+		{`<div><div lang="en">Long… ` + ctrl + `</div></div>`, `<div><div lang="en">Long… </div></div>`, true},
+		{`<div><span data-testid="tweet-text-show-more-link" role="button">Show more</span></div>`, `<div></div>`, true},
+	} {
+		root, err := html.Parse(strings.NewReader(tc.orig))
+		if err != nil {
+			t.Fatalf("Failed parsing %q: %v", tc.orig, err)
+		}
+		n := findFirstNode(root, matchFunc("body")).FirstChild
+		found := removeShowMore(n)
+
+		var b bytes.Buffer
+		if err := html.Render(&b, n); err != nil {
+			t.Fatal("Failed rendering tree: ", err)
+		}
+		if got := b.String(); got != tc.want || found != tc.found {
+			t.Errorf("removeShowMore(%q) = %v and produced %q; want %v and %q",
+				tc.orig, found, got, tc.found, tc.want)
+		}
+	}
+}
+
+func TestParseCount(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"315", 315},
+		{"2,536", 2536},
+		{"64.6K", 64600},
+		{"570.9K", 570900},
+		{"3M", 3000000},
+		{"1.2M", 1200000},
+	} {
+		if got, err := parseCount(tc.in); err != nil {
+			t.Errorf("parseCount(%q) failed: %v", tc.in, err)
+		} else if got != tc.want {
+			t.Errorf("parseCount(%q) = %v; want %v", tc.in, got, tc.want)
+		}
+	}
+	if got, err := parseCount("bogus"); err == nil {
+		t.Errorf("parseCount(%q) unexpectedly returned %v", "bogus", got)
+	}
+}
+
+func TestIsPinned(t *testing.T) {
+	const tmpl = `<article><div><span data-testid="socialContext">%s</span></div>` +
+		`<div><div data-testid="tweet"><div></div><div>text</div></div></div></article>`
+	for _, tc := range []struct {
+		context string
+		want    bool
+	}{
+		{"Pinned Tweet", true},
+		{"<span>Pinned Tweet</span>", true},
+		{"<span>Some User</span> Retweeted", false},
+		{"", false},
+	} {
+		markup := fmt.Sprintf(tmpl, tc.context)
+		root, err := html.Parse(strings.NewReader(markup))
+		if err != nil {
+			t.Fatalf("Failed parsing %q: %v", markup, err)
+		}
+		tn := findFirstNode(root, matchFunc("div", "data-testid=tweet"))
+		if got := isPinned(tn); got != tc.want {
+			t.Errorf("isPinned(%q) = %v; want %v", markup, got, tc.want)
+		}
+	}
+}
+
 const tweetsTmpl = `<!DOCTYPE html>
 <html lang="en">
   <head>
@@ -158,6 +293,7 @@ const tweetsTmpl = `<!DOCTYPE html>
         width: 24px;
       }
       .profile .user { color: #888 }
+      .details .banner { max-width: 300px }
       .tweet .head {
         font-weight: bold;
         margin: 8px;
@@ -175,6 +311,8 @@ const tweetsTmpl = `<!DOCTYPE html>
         max-width: 300px;
       }
       .tweet .text { display: none }
+      .tweet .entities { display: none }
+      .tweet .stats { display: none }
       .tweet hr { background-color: #ddd }
       .sep { background-color: #333 }
     </style>
@@ -184,10 +322,22 @@ const tweetsTmpl = `<!DOCTYPE html>
       <img src="{{.Profile.Icon}}">
       {{.Profile.Name}}
       <span class="user">@{{.Profile.User}}</span>
+      {{- if .Profile.Verified}}<span class="verified">✓</span>{{end}}
+      {{- if .Profile.Protected}}<span class="protected">🔒</span>{{end}}
+    </div>
+    {{- with .Profile}}
+    <div class="details">
+      {{- if .Banner}}<img class="banner" src="{{.Banner}}">{{end}}
+      <div class="bio">{{.Bio}}</div>
+      <div class="location">{{.Location}}</div>
+      <div class="website">{{.Website}}</div>
+      <div class="joined">{{.Joined.Format "January 2006"}}</div>
+      <div class="counts">{{.Following}} following, {{.Followers}} followers</div>
     </div>
+    {{- end}}
     <hr class="sep">
     {{range .Tweets -}}
-    <div class="tweet">
+    <div class="tweet"{{with .Lang}} lang="{{.}}"{{end}}>
       <div class="head">
         <a href="{{.Href}}">
           <span class="id">{{.ID}}</span>
@@ -204,8 +354,68 @@ const tweetsTmpl = `<!DOCTYPE html>
       {{- end}}
       <div class="content">{{Raw .Content}}</div>
       <div class="text">{{.Text}}</div>
+      <div class="stats">
+        {{- .Replies}} replies, {{.Retweets}} retweets, {{.Likes}} likes
+        {{- if .Retweet}}, retweet{{end}}{{if .Quote}}, quote{{end}}{{if .Media}}, media{{end}}
+      </div>
+      {{- with .Card}}
+      <div class="card">{{.Layout}} {{.URL}} {{.Image}}</div>
+      {{- end}}
+      {{- if or .Hashtags .Mentions .Cashtags .URLs}}
+      <div class="entities">
+        {{- range .Hashtags}} #{{.}}{{end}}
+        {{- range .Mentions}} @{{.}}{{end}}
+        {{- range .Cashtags}} ${{.}}{{end}}
+        {{- range .URLs}} {{.}}{{end}}
+      </div>
+      {{- end}}
     </div>
     <hr class="sep">
     {{- end}}
   </body>
 </html>`
+
+func TestReplyToID(t *testing.T) {
+	for _, tc := range []struct {
+		markup string
+		want   int64
+	}{
+		{`<div>Replying to <a href="/user">@user</a></div>`, 0},
+		{`<div>Replying to <a href="/user">@user</a> <a href="/user/status/123">Show</a></div>`, 123},
+		{`<div><a href="https://twitter.com/user/status/456">@user</a></div>`, 456},
+		{`<div><a href="/user/status/123/photo/1">@user</a></div>`, 0},
+	} {
+		root, err := html.Parse(strings.NewReader(tc.markup))
+		if err != nil {
+			t.Fatalf("Failed parsing %q: %v", tc.markup, err)
+		}
+		if got := replyToID(findFirstNode(root, matchFunc("div"))); got != tc.want {
+			t.Errorf("replyToID(%q) = %v; want %v", tc.markup, got, tc.want)
+		}
+	}
+}
+
+func TestTweetHasLang(t *testing.T) {
+	for _, tc := range []struct {
+		lang             string
+		langs, skipLangs []string
+		want             bool
+	}{
+		{"en", nil, nil, true},
+		{"en", []string{"en"}, nil, true},
+		{"en", []string{"es", "EN"}, nil, true},
+		{"en-GB", []string{"en"}, nil, true},
+		{"es", []string{"en"}, nil, false},
+		{"es", nil, []string{"es"}, false},
+		{"es", []string{"es"}, []string{"es"}, false},
+		{"und", []string{"en"}, nil, true},
+		{"", []string{"en"}, nil, true},
+		{"und", nil, []string{"und"}, false},
+		{"", nil, []string{"und"}, false},
+	} {
+		tw := tweet{Lang: tc.lang}
+		if got := tw.hasLang(tc.langs, tc.skipLangs); got != tc.want {
+			t.Errorf("hasLang(%q, %q) for %q = %v; want %v", tc.langs, tc.skipLangs, tc.lang, got, tc.want)
+		}
+	}
+}
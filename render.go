@@ -0,0 +1,356 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// contentType describes how tweet content is written.
+type contentType string
+
+const (
+	htmlContent     contentType = "html"
+	textContent     contentType = "text"
+	markdownContent contentType = "markdown"
+)
+
+// renderContent returns t's content in the supplied format.
+func (t *tweet) renderContent(ct contentType) (string, error) {
+	switch ct {
+	case htmlContent:
+		return t.Content, nil
+	case textContent, markdownContent:
+		nodes, err := html.ParseFragment(strings.NewReader(t.Content),
+			&html.Node{Type: html.ElementNode, DataAtom: atom.Body, Data: "body"})
+		if err != nil {
+			return "", err
+		}
+		root := &html.Node{Type: html.ElementNode, DataAtom: atom.Div, Data: "div"}
+		for _, n := range nodes {
+			root.AppendChild(n)
+		}
+		if ct == textContent {
+			return renderText(root), nil
+		}
+		return renderMarkdown(root), nil
+	default:
+		return "", fmt.Errorf("unknown content type %q", ct)
+	}
+}
+
+// renderText returns a readable plain-text rendering of the content under n.
+// Line breaks and paragraphs are preserved, links are replaced by their URLs
+// (or followed by them if the link text isn't a URL), images are replaced by
+// descriptions (see imageDesc), and quoted tweets are prefixed by "> ".
+func renderText(n *html.Node) string {
+	r := textRenderer{}
+	r.children(n)
+	return r.String()
+}
+
+// renderMarkdown returns a Markdown rendering of the content under n.
+func renderMarkdown(n *html.Node) string {
+	r := textRenderer{md: true}
+	r.children(n)
+	return r.String()
+}
+
+// textRenderer renders an HTML tree as plain text or Markdown.
+type textRenderer struct {
+	md bool // write Markdown instead of plain text
+	b  strings.Builder
+}
+
+// String returns the rendered text with surrounding whitespace and extra blank lines removed.
+func (r *textRenderer) String() string {
+	return strings.TrimSpace(extraNewlinesRegexp.ReplaceAllString(r.b.String(), "\n\n"))
+}
+
+var extraNewlinesRegexp = regexp.MustCompile(`\n{3,}`)
+
+// sub returns the rendering of n's children by a new renderer using the same format.
+func (r *textRenderer) sub(n *html.Node) string {
+	sr := textRenderer{md: r.md}
+	sr.children(n)
+	return sr.String()
+}
+
+// write appends s, dropping spaces at the start of lines and duplicate spaces.
+func (r *textRenderer) write(s string) {
+	if s == "" {
+		return
+	}
+	if cur := r.b.String(); cur == "" || strings.HasSuffix(cur, "\n") || strings.HasSuffix(cur, " ") {
+		s = strings.TrimLeft(s, " ")
+	}
+	r.b.WriteString(s)
+}
+
+// newlines ensures that the output ends with at least cnt newlines
+// (unless it's empty), removing trailing spaces.
+func (r *textRenderer) newlines(cnt int) {
+	cur := strings.TrimRight(r.b.String(), " ")
+	if cur == "" {
+		r.b.Reset()
+		return
+	}
+	have := len(cur) - len(strings.TrimRight(cur, "\n"))
+	r.b.Reset()
+	r.b.WriteString(cur)
+	for ; have < cnt; have++ {
+		r.b.WriteByte('\n')
+	}
+}
+
+// markdownEscaper escapes characters that have special meanings in Markdown text.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`, `<`, `\<`)
+
+// markdownURLEscaper percent-encodes characters that would end Markdown link destinations
+// and autolinks early, e.g. the parentheses in "https://en.wikipedia.org/wiki/Go_(game)".
+var markdownURLEscaper = strings.NewReplacer(
+	" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E", "\n", "%0A")
+
+// text writes the text from tn, a text node, collapsing whitespace as a browser would.
+func (r *textRenderer) text(tn *html.Node) {
+	s := strings.Join(strings.Fields(tn.Data), " ")
+	if s == "" {
+		if tn.Data != "" {
+			r.write(" ")
+		}
+		return
+	}
+	if r.md {
+		s = markdownEscaper.Replace(s)
+	}
+	if strings.TrimLeft(tn.Data, " \t\n\r\f") != tn.Data {
+		s = " " + s
+	}
+	if strings.TrimRight(tn.Data, " \t\n\r\f") != tn.Data {
+		s += " "
+	}
+	r.write(s)
+}
+
+func (r *textRenderer) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		r.node(c)
+	}
+}
+
+func (r *textRenderer) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		r.text(n)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	switch n.Data {
+	case "br":
+		r.trimSpaces()
+		r.b.WriteByte('\n')
+	case "hr":
+		r.newlines(2)
+		if r.md {
+			r.b.WriteString("---")
+			r.newlines(2)
+		}
+	case "p":
+		r.newlines(2)
+		r.children(n)
+		r.newlines(2)
+	case "div":
+		r.newlines(1)
+		r.children(n)
+		r.newlines(1)
+	case "li":
+		r.newlines(1)
+		r.write("- ")
+		r.children(n)
+		r.newlines(1)
+	case "ul", "ol":
+		r.newlines(2)
+		r.children(n)
+		r.newlines(2)
+	case "blockquote":
+		r.newlines(2)
+		r.b.WriteString(quoteLines(r.sub(n)))
+		r.newlines(2)
+	case "b", "strong", "i", "em":
+		r.emphasis(n)
+	case "a":
+		r.link(n)
+	case "img":
+		r.image(n)
+	case "video":
+		r.video(n)
+	case "script", "style", "svg", "template":
+		// Skip these entirely.
+	default:
+		r.children(n)
+	}
+}
+
+// trimSpaces removes trailing spaces from the output.
+func (r *textRenderer) trimSpaces() {
+	if cur := r.b.String(); strings.HasSuffix(cur, " ") {
+		r.b.Reset()
+		r.b.WriteString(strings.TrimRight(cur, " "))
+	}
+}
+
+// quoteLines prefixes each line in s with "> ".
+func quoteLines(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, ln := range lines {
+		if ln == "" {
+			lines[i] = ">"
+		} else {
+			lines[i] = "> " + ln
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// emphasis renders n, a <b> or <i> element.
+func (r *textRenderer) emphasis(n *html.Node) {
+	inner := r.sub(n)
+	if !r.md || inner == "" || strings.Contains(inner, "\n") {
+		r.children(n)
+		return
+	}
+	mark := "_"
+	if n.Data == "b" || n.Data == "strong" {
+		mark = "**"
+	}
+	if first := n.FirstChild; first != nil && isText(first) && strings.HasPrefix(first.Data, " ") {
+		r.write(" ")
+	}
+	r.write(mark + inner + mark)
+}
+
+// link renders n, an <a> element.
+func (r *textRenderer) link(n *html.Node) {
+	href := getAttr(n, "href")
+	inner := r.sub(n)
+	if href == "" {
+		r.children(n)
+		return
+	}
+
+	// Links containing multiple lines (e.g. link cards) are written as blocks
+	// followed by their URLs.
+	if strings.Contains(inner, "\n") {
+		r.newlines(1)
+		r.b.WriteString(inner)
+		r.newlines(1)
+		if r.md {
+			r.b.WriteString("<" + markdownURLEscaper.Replace(href) + ">")
+		} else {
+			r.b.WriteString(href)
+		}
+		r.newlines(1)
+		return
+	}
+
+	// Put media (e.g. images linking to photo pages) on their own lines.
+	if findFirstNode(n, matchFunc("img")) != nil {
+		r.newlines(1)
+		defer r.newlines(1)
+	}
+
+	if r.md {
+		if inner == "" {
+			r.write("<" + markdownURLEscaper.Replace(href) + ">")
+		} else {
+			r.write("[" + inner + "](" + markdownURLEscaper.Replace(href) + ")")
+		}
+		return
+	}
+
+	text := getText(n, false)
+	u, err := url.Parse(href)
+	internal := err == nil && (u.Host == defaultHost || u.Host == mobileHost)
+	switch {
+	case inner == "":
+		// Skip links that just wrap unlabeled images (e.g. link card thumbnails),
+		// since the same URL is also present elsewhere.
+		if findFirstNode(n, matchFunc("img")) == nil {
+			r.write(href)
+		}
+	case internal && strings.IndexAny(text, "@#$") == 0:
+		r.write(inner) // hashtags, mentions, and cashtags
+	case isURLText(text):
+		// Prefer the link text if it's a complete URL and the link just points at t.co.
+		if t := strings.TrimSpace(text); err == nil && u.Host == shortLinkHost &&
+			!strings.HasSuffix(t, "…") && (strings.HasPrefix(t, "https://") || strings.HasPrefix(t, "http://")) {
+			r.write(t)
+		} else {
+			r.write(href)
+		}
+	default:
+		r.write(inner + " (" + href + ")")
+	}
+}
+
+// isURLText returns true if s, a link's text, appears to be a (possibly shortened) URL.
+func isURLText(s string) bool {
+	s = strings.TrimRight(strings.TrimSpace(s), "…")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "https://"), "http://")
+	return s != "" && !strings.ContainsAny(s, " \n") && strings.Contains(s, ".")
+}
+
+// image renders n, an <img> element.
+func (r *textRenderer) image(n *html.Node) {
+	desc := imageDesc(n)
+	if !r.md {
+		r.write(desc)
+		return
+	}
+	src := getAttr(n, "src")
+	if desc == "" && !isCardImage(src) {
+		return // skip avatars, hashflags, etc.
+	}
+	r.write("![" + markdownEscaper.Replace(getAttr(n, "alt")) + "](" + markdownURLEscaper.Replace(src) + ")")
+}
+
+// video renders n, a <video> element.
+func (r *textRenderer) video(n *html.Node) {
+	r.newlines(1)
+	defer r.newlines(1)
+
+	src := getAttr(n, "src")
+	if src == "" {
+		if s := findFirstNode(n, matchFunc("source", "src")); s != nil {
+			src = getAttr(s, "src")
+		}
+	}
+	poster := getAttr(n, "poster")
+	if r.md {
+		src, poster = markdownURLEscaper.Replace(src), markdownURLEscaper.Replace(poster)
+	}
+	switch {
+	case !r.md && src != "":
+		r.write("[video] " + src)
+	case !r.md:
+		r.write("[video]")
+	case poster != "" && src != "":
+		r.write("[![" + defaultVideoAlt + "](" + poster + ")](" + src + ")")
+	case src != "":
+		r.write("[" + defaultVideoAlt + "](" + src + ")")
+	case poster != "":
+		r.write("![" + defaultVideoAlt + "](" + poster + ")")
+	}
+}
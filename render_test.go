@@ -0,0 +1,79 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestRenderContent(t *testing.T) {
+	const (
+		media = "https://pbs.twimg.com/media/abc?format=jpg&amp;name=small"
+		photo = "https://twitter.com/user/status/123/photo/1"
+	)
+	for _, tc := range []struct {
+		content string
+		text    string
+		md      string
+	}{
+		{
+			`<div><span>First line<br>second <a href="https://twitter.com/hashtag/foo?src=hashtag_click">#foo</a>` +
+				`<br><br>by <a href="https://twitter.com/some_user">@some_user</a></span></div>`,
+			"First line\nsecond #foo\n\nby @some_user",
+			"First line\nsecond [#foo](https://twitter.com/hashtag/foo?src=hashtag_click)\n\n" +
+				"by [@some\\_user](https://twitter.com/some_user)",
+		},
+		{
+			`<div>See <a href="https://example.org/a/long/path">example.org/a/long…</a> ` +
+				`and <a href="https://t.co/abc">https://example.org/</a> or <a href="https://example.org/">this</a></div>`,
+			"See https://example.org/a/long/path and https://example.org/ or this (https://example.org/)",
+			"See [example.org/a/long…](https://example.org/a/long/path) and " +
+				"[https://example.org/](https://t.co/abc) or [this](https://example.org/)",
+		},
+		{
+			`<div><a href="https://en.wikipedia.org/wiki/Go_(game)">Go</a> ` +
+				`<a href="https://example.org/a b<c>">x</a></div>`,
+			"Go (https://en.wikipedia.org/wiki/Go_(game)) x (https://example.org/a b<c>)",
+			"[Go](https://en.wikipedia.org/wiki/Go_%28game%29) [x](https://example.org/a%20b%3Cc%3E)",
+		},
+		{
+			`<div>Look *here*<hr><br><div><a href="` + photo + `"><img alt="A dog" src="` + media + `"/></a></div></div>`,
+			"Look *here*\n\n[image: A dog] (" + photo + ")",
+			"Look \\*here\\*\n\n---\n\n[![A dog](https://pbs.twimg.com/media/abc?format=jpg&name=small)](" + photo + ")",
+		},
+		{
+			`<div>Quoting<hr><br><blockquote><div><div><b> Name @user · Dec 21</b></div>` +
+				`<div>Quoted<br>text</div></div></blockquote></div>`,
+			"Quoting\n\n> Name @user · Dec 21\n> Quoted\n> text",
+			"Quoting\n\n---\n\n> **Name @user · Dec 21**\n> Quoted\n> text",
+		},
+		{
+			`<div><video poster="https://pbs.twimg.com/tweet_video_thumb/x.jpg" controls="">` +
+				`<source src="https://video.twimg.com/tweet_video/x.mp4" type="video/mp4"/></video></div>`,
+			"[video] https://video.twimg.com/tweet_video/x.mp4",
+			"[![Video](https://pbs.twimg.com/tweet_video_thumb/x.jpg)](https://video.twimg.com/tweet_video/x.mp4)",
+		},
+	} {
+		tw := tweet{Content: tc.content}
+		if got, err := tw.renderContent(textContent); err != nil {
+			t.Errorf("renderContent(%q, text) failed: %v", tc.content, err)
+		} else if got != tc.text {
+			t.Errorf("renderContent(%q, text) = %q; want %q", tc.content, got, tc.text)
+		}
+		if got, err := tw.renderContent(markdownContent); err != nil {
+			t.Errorf("renderContent(%q, markdown) failed: %v", tc.content, err)
+		} else if got != tc.md {
+			t.Errorf("renderContent(%q, markdown) = %q; want %q", tc.content, got, tc.md)
+		}
+		if got, err := tw.renderContent(htmlContent); err != nil || got != tc.content {
+			t.Errorf("renderContent(%q, html) = %q, %v; want %q", tc.content, got, err, tc.content)
+		}
+	}
+
+	tw := tweet{Content: "<div></div>"}
+	if _, err := tw.renderContent("bogus"); err == nil {
+		t.Error("renderContent with bogus type unexpectedly succeeded")
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// rulesVersion is the current version of the rules file format.
+// It should be incremented when fields are renamed or their meanings change.
+const rulesVersion = 1
+
+// selector describes HTML elements in Twitter's DOM.
+type selector struct {
+	Tag   string   `json:"tag"`             // element tag, e.g. "div"
+	Attrs []string `json:"attrs,omitempty"` // attribute expressions accepted by matchFunc
+}
+
+// match returns true if n is matched by s.
+func (s selector) match(n *html.Node) bool {
+	return matchFunc(s.Tag, s.Attrs...)(n)
+}
+
+// css returns a CSS selector equivalent to s, e.g. `div[data-testid="tweet"]`.
+func (s selector) css() string {
+	var b strings.Builder
+	b.WriteString(s.Tag)
+	for _, expr := range s.Attrs {
+		parts := strings.SplitN(expr, "=", 2)
+		switch {
+		case len(parts) == 1:
+			fmt.Fprintf(&b, "[%s]", parts[0])
+		case parts[0] == "class":
+			fmt.Fprintf(&b, "[class~=%q]", parts[1])
+		default:
+			fmt.Fprintf(&b, "[%s=%q]", parts[0], parts[1])
+		}
+	}
+	if b.Len() == 0 {
+		return "*"
+	}
+	return b.String()
+}
+
+// ruleSet contains the selectors and JavaScript expressions that are used to fetch and
+// parse timelines. These depend on the details of Twitter's DOM, so they can be
+// overridden via a JSON file (see -rules) when Twitter changes its layout.
+type ruleSet struct {
+	Version int `json:"version"`
+
+	PrimaryColumn   selector    `json:"primaryColumn"`   // column containing profile and timeline
+	Tweet           selector    `json:"tweet"`           // element wrapping each tweet
+	SocialContext   selector    `json:"socialContext"`   // e.g. "Pinned Tweet" above a tweet
+	PinnedContext   string      `json:"pinnedContext"`   // social context text for pinned tweets
+	Emoji           selector    `json:"emoji"`           // element wrapping each emoji image
+	LinkCards       []selector  `json:"linkCards"`       // link card title/description/domain elements
+	ShowMore        selector    `json:"showMore"`        // "Show more" control in truncated tweets
+	CommunityNote   selector    `json:"communityNote"`   // Community Note ("Readers added context")
+	ContentLabels   []labelRule `json:"contentLabels"`   // labels and warnings displayed in tweets
+	ReplyButton     selector    `json:"replyButton"`     // reply button containing count
+	RetweetButton   selector    `json:"retweetButton"`   // retweet button containing count
+	LikeButton      selector    `json:"likeButton"`      // like button containing count
+	UserDescription selector    `json:"userDescription"` // profile bio
+	UserItems       selector    `json:"userItems"`       // profile location, website, and join date
+
+	HasTweetExpr      string `json:"hasTweetExpr"`      // evaluates to true after tweets are loaded (see hasTweetExpr)
+	LoadFailedExpr    string `json:"loadFailedExpr"`    // evaluates to true if loading tweets failed
+	ProtectedExpr     string `json:"protectedExpr"`     // evaluates to true if tweets are protected
+	ShowSensitiveExpr string `json:"showSensitiveExpr"` // shows sensitive content, marks articles with sensitiveAttr, and returns count
+
+	// Truncated tweets are expanded by first running ShowMoreExpr to activate inline "Show more"
+	// buttons. Status pages of tweets listed by TruncatedTweetsExpr are then loaded in new tabs,
+	// FullTextExpr is run in each to get the full text, and ReplaceTextFunc is called with the
+	// tweet's URL and the full text to update the timeline.
+	ShowMoreExpr        string `json:"showMoreExpr"`        // expands truncated tweets and returns count
+	TruncatedTweetsExpr string `json:"truncatedTweetsExpr"` // returns URLs of still-truncated tweets
+	FullTextExpr        string `json:"fullTextExpr"`        // returns HTML of status page's tweet text
+	ReplaceTextFunc     string `json:"replaceTextFunc"`     // replaces truncated text; returns true on success
+}
+
+// defaultRules returns the built-in rules.
+func defaultRules() ruleSet {
+	return ruleSet{
+		Version: rulesVersion,
+
+		PrimaryColumn: selector{"div", []string{"data-testid=primaryColumn"}},
+		Tweet:         selector{"div", []string{"data-testid=tweet"}},
+		SocialContext: selector{"span", []string{"data-testid=socialContext"}},
+		PinnedContext: "Pinned Tweet",
+		Emoji:         selector{"div", []string{"style=height: 1.2em;", "aria-label"}},
+		LinkCards: []selector{
+			{"div", []string{"data-testid=card.layoutSmall.detail"}},
+			{"div", []string{"data-testid=card.layoutLarge.detail"}},
+		},
+		ShowMore:      selector{"", []string{"data-testid=tweet-text-show-more-link"}},
+		CommunityNote: selector{"div", []string{"data-testid=birdwatch-pivot"}},
+		ContentLabels: []labelRule{
+			{sensitiveLabel, "The following media includes potentially sensitive content"},
+			{sensitiveLabel, "Content warning"},
+			{manipulatedLabel, "Manipulated media"},
+			{misleadingLabel, "Stay informed"},
+			{misleadingLabel, "Get the facts"},
+			{misleadingLabel, "This claim is disputed"},
+		},
+		ReplyButton:     selector{"div", []string{"data-testid=reply"}},
+		RetweetButton:   selector{"div", []string{"data-testid=retweet"}},
+		LikeButton:      selector{"div", []string{"data-testid=like"}},
+		UserDescription: selector{"div", []string{"data-testid=UserDescription"}},
+		UserItems:       selector{"div", []string{"data-testid=UserProfileHeader_Items"}},
+
+		LoadFailedExpr: `!!Array.from(document.querySelectorAll('div[role="button"]'))` +
+			`.find(e => e.innerText === 'Try again')`,
+		ProtectedExpr: `!!Array.from(document.querySelectorAll('span'))` +
+			`.find(e => e.innerText === 'These Tweets are protected')`,
+		ShowSensitiveExpr: `Array.from(document.querySelectorAll('article div[role=button]'))` +
+			`.filter(e => e.innerText === 'View').map(e => {` +
+			`const a = e.closest('article'); if (a) a.setAttribute('` + sensitiveAttr + `', ''); ` +
+			`e.click(); return true; }).length`,
+
+		ShowMoreExpr: `Array.from(document.querySelectorAll(` +
+			`'article [data-testid="tweet-text-show-more-link"][role=button]'))` +
+			`.map(e => e.click() || true).length`,
+		TruncatedTweetsExpr: `Array.from(document.querySelectorAll(` +
+			`'article a[data-testid="tweet-text-show-more-link"][href]')).map(e => e.href)`,
+		FullTextExpr: `(document.querySelector('article[tabindex="-1"] div[lang]') || {}).innerHTML || ''`,
+		ReplaceTextFunc: `(href, html) => {
+  const link = Array.from(document.querySelectorAll(
+    'article a[data-testid="tweet-text-show-more-link"][href]')).find(e => e.href === href);
+  const text = link && link.closest('article').querySelector('div[lang]');
+  if (!text) return false;
+  text.innerHTML = html;
+  link.remove();
+  return true;
+}`,
+	}
+}
+
+// hasTweetExpr returns rs.HasTweetExpr if it's non-empty. Otherwise, an expression
+// checking for an element matched by rs.Tweet is returned.
+func (rs *ruleSet) hasTweetExpr() string {
+	if rs.HasTweetExpr != "" {
+		return rs.HasTweetExpr
+	}
+	b, _ := json.Marshal(rs.Tweet.css())
+	return fmt.Sprintf("!!document.querySelector(%s)", b)
+}
+
+// rules holds the rules that are currently in use.
+var rules = defaultRules()
+
+// loadRules reads a JSON rules file from p.
+// Fields that aren't present in the file are copied from defaultRules.
+func loadRules(p string) (ruleSet, error) {
+	rs := defaultRules()
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return rs, err
+	}
+
+	// Require the version to be supplied explicitly so we can't get confused by old files.
+	var ver struct {
+		Version *int `json:"version"`
+	}
+	if err := json.Unmarshal(b, &ver); err != nil {
+		return rs, err
+	}
+	if ver.Version == nil {
+		return rs, errors.New("missing version")
+	}
+	if *ver.Version < 1 || *ver.Version > rulesVersion {
+		return rs, fmt.Errorf("unsupported version %d (want 1 to %d)", *ver.Version, rulesVersion)
+	}
+
+	if err := json.Unmarshal(b, &rs); err != nil {
+		return rs, err
+	}
+	return rs, nil
+}
+
+// dumpRules writes the current rules to stdout as JSON.
+func dumpRules() error {
+	b, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(append(b, '\n'))
+	return err
+}
+
+// validateRules parses each of the supplied HTML timeline files (e.g. as written by -dump-dom)
+// using the current rules and prints a summary of the results. An error is returned if
+// any of the files can't be parsed or don't contain tweets.
+func validateRules(paths []string, opts parseOptions) error {
+	var failed []string
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		prof, tweets, warnings, err := parseTimeline(f, "", opts)
+		f.Close()
+		if err == nil && len(tweets) == 0 {
+			err = errors.New("no tweets found")
+		}
+		if err != nil {
+			fmt.Printf("%v: %v\n", p, err)
+			failed = append(failed, p)
+			continue
+		}
+		fmt.Printf("%v: @%v, %d tweet(s), %d skipped\n", p, prof.User, len(tweets), len(warnings))
+		for _, w := range warnings {
+			fmt.Printf("  %v\n", w)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed on %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
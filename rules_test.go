@@ -0,0 +1,85 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "twittuh.rules_test.")
+	if err != nil {
+		t.Fatal("Failed creating temp dir: ", err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(data string) string {
+		p := filepath.Join(dir, "rules.json")
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal("Failed writing rules: ", err)
+		}
+		return p
+	}
+
+	// Fields that aren't specified should retain their default values.
+	got, err := loadRules(write(`{"version": 1, "tweet": {"tag": "article", "attrs": ["role=article"]}}`))
+	if err != nil {
+		t.Fatal("loadRules failed: ", err)
+	}
+	want := defaultRules()
+	want.Tweet = selector{"article", []string{"role=article"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error("loadRules returned unexpected rules:\n" + diff)
+	}
+
+	for _, data := range []string{
+		`{"tweet": {"tag": "article"}}`, // missing version
+		`{"version": 0}`,
+		`{"version": 1000}`,
+		`{"version": 1, "tweet": "article"}`,
+	} {
+		if _, err := loadRules(write(data)); err == nil {
+			t.Errorf("loadRules unexpectedly succeeded for %s", data)
+		}
+	}
+}
+
+func TestHasTweetExpr(t *testing.T) {
+	rs := defaultRules()
+	if got, want := rs.hasTweetExpr(), `!!document.querySelector("div[data-testid=\"tweet\"]")`; got != want {
+		t.Errorf("hasTweetExpr() with default rules = %s; want %s", got, want)
+	}
+
+	// Overriding the tweet selector should also update the expression.
+	rs.Tweet = selector{"article", []string{"role=article", "class=tweet", "data-id"}}
+	if got, want := rs.hasTweetExpr(), `!!document.querySelector("article[role=\"article\"][class~=\"tweet\"][data-id]")`; got != want {
+		t.Errorf("hasTweetExpr() with overridden tweet = %s; want %s", got, want)
+	}
+
+	// An explicitly-supplied expression should be used as-is.
+	rs.HasTweetExpr = "true"
+	if got := rs.hasTweetExpr(); got != "true" {
+		t.Errorf("hasTweetExpr() with explicit expression = %s; want true", got)
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	const fn = "testdata/NWS-20201231.html"
+	orig := rules
+	defer func() { rules = orig }()
+
+	if err := validateRules([]string{fn}, parseOptions{simplify: true}); err != nil {
+		t.Error("Validation with default rules failed: ", err)
+	}
+	rules.Tweet = selector{"div", []string{"data-testid=bogus"}}
+	if err := validateRules([]string{fn}, parseOptions{simplify: true}); err == nil {
+		t.Error("Validation with bogus tweet selector unexpectedly succeeded")
+	}
+}
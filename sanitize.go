@@ -0,0 +1,169 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// allowedAttrs lists the elements that may appear in sanitized content and
+// the attributes (beyond globalAttrs) permitted on each of them.
+var allowedAttrs = map[string][]string{
+	"a":          {"href", "title"},
+	"b":          nil,
+	"blockquote": nil,
+	"br":         nil,
+	"code":       nil,
+	"div":        nil,
+	"em":         nil,
+	"hr":         nil,
+	"i":          nil,
+	"img":        {"src", "srcset", "alt", "title", "width", "height"},
+	"li":         nil,
+	"ol":         nil,
+	"p":          nil,
+	"pre":        nil,
+	"s":          nil,
+	"small":      nil,
+	"source":     {"src", "type"},
+	"span":       nil,
+	"strong":     nil,
+	"sub":        nil,
+	"sup":        nil,
+	"u":          nil,
+	"ul":         nil,
+	"video":      {"src", "poster", "controls", "preload", "playsinline", "loop", "muted"},
+}
+
+// globalAttrs lists attributes permitted on all allowed elements.
+var globalAttrs = []string{"dir", "lang"}
+
+// droppedElements lists elements that are removed along with their contents.
+// Other disallowed elements are replaced by their children.
+var droppedElements = map[string]struct{}{
+	"audio": {}, "base": {}, "button": {}, "canvas": {}, "embed": {}, "form": {},
+	"frame": {}, "frameset": {}, "iframe": {}, "input": {}, "link": {}, "math": {},
+	"meta": {}, "noscript": {}, "object": {}, "script": {}, "select": {}, "style": {},
+	"svg": {}, "template": {}, "textarea": {},
+}
+
+// urlAttrs lists attributes containing URLs.
+var urlAttrs = map[string]struct{}{"href": {}, "poster": {}, "src": {}}
+
+// sanitizeContent strips everything except allowlisted elements and attributes
+// (see allowedAttrs) from under root, which is itself left unchanged. URLs are
+// resolved against Twitter's site, and attributes with non-HTTP URLs (e.g.
+// "javascript:" or "data:") are removed.
+func sanitizeContent(root *html.Node) {
+	for c := root.FirstChild; c != nil; {
+		next := c.NextSibling
+		sanitizeNode(c)
+		c = next
+	}
+}
+
+// sanitizeHTML returns s, an HTML fragment, after sanitizing it with sanitizeContent.
+// It's used for content that's read back from archives and feeds rather than parsed from Twitter.
+func sanitizeHTML(s string) (string, error) {
+	nodes, err := html.ParseFragment(strings.NewReader(s),
+		&html.Node{Type: html.ElementNode, DataAtom: atom.Body, Data: "body"})
+	if err != nil {
+		return "", err
+	}
+	root := &html.Node{Type: html.ElementNode, DataAtom: atom.Div, Data: "div"}
+	for _, n := range nodes {
+		root.AppendChild(n)
+	}
+	sanitizeContent(root)
+	var b strings.Builder
+	for n := root.FirstChild; n != nil; n = n.NextSibling {
+		if err := html.Render(&b, n); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// sanitizeNode sanitizes n and its descendants. n may be removed from its parent.
+func sanitizeNode(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		return
+	case html.ElementNode:
+		// Handled below.
+	default:
+		n.Parent.RemoveChild(n) // comments, doctypes, etc.
+		return
+	}
+
+	tag := strings.ToLower(n.Data)
+	if _, ok := droppedElements[tag]; ok {
+		n.Parent.RemoveChild(n)
+		return
+	}
+
+	sanitizeContent(n)
+
+	allowed, ok := allowedAttrs[tag]
+	if !ok {
+		promoteChildren(n)
+		return
+	}
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		if a.Namespace != "" || (!hasString(allowed, a.Key) && !hasString(globalAttrs, a.Key)) {
+			continue
+		}
+		if _, ok := urlAttrs[a.Key]; ok {
+			if a.Val = safeURL(a.Val, a.Key == "href"); a.Val == "" {
+				continue
+			}
+		} else if a.Key == "srcset" {
+			if a.Val = safeSrcset(a.Val); a.Val == "" {
+				continue
+			}
+		}
+		attrs = append(attrs, a)
+	}
+	n.Attr = attrs
+}
+
+// safeURL returns an absolute version of u if it uses an HTTP scheme (or "mailto" if
+// allowMailto is true). An empty string is returned for all other URLs.
+func safeURL(u string, allowMailto bool) string {
+	pu, err := url.Parse(strings.TrimSpace(absoluteURL(strings.TrimSpace(u))))
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(pu.Scheme) {
+	case "http", "https":
+		return pu.String()
+	case "mailto":
+		if allowMailto {
+			return pu.String()
+		}
+	}
+	return ""
+}
+
+// safeSrcset returns a version of srcset, an <img> srcset attribute value,
+// containing only candidates with safe URLs (see safeURL).
+func safeSrcset(srcset string) string {
+	var cands []string
+	for _, c := range strings.Split(srcset, ",") {
+		fields := strings.Fields(c)
+		if len(fields) == 0 {
+			continue
+		}
+		if u := safeURL(fields[0], false); u != "" {
+			cands = append(cands, strings.Join(append([]string{u}, fields[1:]...), " "))
+		}
+	}
+	return strings.Join(cands, ", ")
+}
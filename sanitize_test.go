@@ -0,0 +1,54 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestSanitizeContent(t *testing.T) {
+	for _, tc := range []struct{ orig, want string }{
+		{`<div lang="en" dir="auto" class="x" style="color: red">Hi</div>`,
+			`<div lang="en" dir="auto">Hi</div>`},
+		{`<a href="/user" onclick="evil()" target="_blank">@user</a>`,
+			`<a href="https://twitter.com/user">@user</a>`},
+		{`<a href="javascript:alert(1)">x</a>`, `<a>x</a>`},
+		{`<a href=" JavaScript:alert(1)">x</a>`, `<a>x</a>`},
+		{`<a href="mailto:a@example.org">x</a>`, `<a href="mailto:a@example.org">x</a>`},
+		{`<img src="data:image/png;base64,AAAA" onerror="evil()" alt="x"/>`, `<img alt="x"/>`},
+		{`<img src="https://pbs.twimg.com/media/a" srcset="https://pbs.twimg.com/media/a 680w, javascript:x 1200w"/>`,
+			`<img src="https://pbs.twimg.com/media/a" srcset="https://pbs.twimg.com/media/a 680w"/>`},
+		{`<video poster="https://pbs.twimg.com/a.jpg" controls="" aria-label="Video">` +
+			`<source src="https://video.twimg.com/a.mp4" type="video/mp4"/></video>`,
+			`<video poster="https://pbs.twimg.com/a.jpg" controls="">` +
+				`<source src="https://video.twimg.com/a.mp4" type="video/mp4"/></video>`},
+		{`<span>a<script>evil()</script>b<iframe src="https://example.org"></iframe>c</span>`,
+			`<span>abc</span>`},
+		{`<div>a<svg><path d="M0"></path></svg><style>* {}</style><!-- x -->b</div>`, `<div>ab</div>`},
+		{`<section><h1>Title</h1><p>Text</p></section>`, `Title<p>Text</p>`},
+		{`<form action="https://example.org"><input name="x"/>Text</form>`, ``},
+	} {
+		root, err := html.Parse(strings.NewReader(tc.orig))
+		if err != nil {
+			t.Fatalf("Failed parsing %q: %v", tc.orig, err)
+		}
+		body := findFirstNode(root, matchFunc("body"))
+		sanitizeContent(body)
+
+		var b bytes.Buffer
+		for c := body.FirstChild; c != nil; c = c.NextSibling {
+			if err := html.Render(&b, c); err != nil {
+				t.Fatal("Failed rendering tree: ", err)
+			}
+		}
+		if got := b.String(); got != tc.want {
+			t.Errorf("sanitizeContent(%q) = %q; want %q", tc.orig, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// siteOptions configures writeSite.
+type siteOptions struct {
+	title    string // title of index page
+	pageSize int    // maximum number of tweets on each timeline page
+}
+
+// siteTweet holds a tweet that is rendered into a site page.
+type siteTweet struct {
+	tweet
+	HTML      template.HTML     // sanitized content
+	Permalink string            // path of permalink page relative to the user's directory
+	Media     []*activityObject // attached images and videos
+}
+
+// siteUser holds an archived user as listed on the site's index page.
+type siteUser struct {
+	Profile profile
+	Dir     string // user's directory relative to the site's root
+	Count   int    // number of archived tweets
+	Latest  *siteTweet
+}
+
+// sitePage holds data passed to siteTmpl.
+type sitePage struct {
+	Title string
+	Root  string // relative path from the page's directory to the site's root
+	Kind  string // "index", "timeline", or "tweet"
+
+	Users []siteUser // for index
+
+	Profile    profile     // for timeline and tweet
+	Tweets     []siteTweet // for timeline and tweet
+	Page       int         // 1-based page number for timeline
+	Pages      int         // number of timeline pages
+	Prev, Next string      // adjacent timeline pages relative to user's directory
+	Back       string      // timeline page containing the tweet
+}
+
+// siteTmpl renders all of the site's pages.
+var siteTmpl = template.Must(template.New("").Funcs(template.FuncMap{
+	"time":    func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 MST") },
+	"title":   func(s string) string { return truncate(titleLen, s) },
+	"userURL": userURL,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{title .Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 40em; margin: 1em auto; padding: 0 1em; }
+.tweet { border-bottom: 1px solid #ccc; padding: 1em 0; }
+.meta, .nav, .media { color: #666; font-size: smaller; }
+img, video { max-width: 100%; height: auto; }
+</style>
+</head>
+<body>
+{{- if eq .Kind "index"}}
+<h1>{{.Title}}</h1>
+<ul class="users">
+{{- range .Users}}
+<li><a href="{{.Dir}}/index.html">{{.Profile.Name}} (@{{.Profile.User}})</a>: {{.Count}} tweet(s)
+{{- with .Latest}}, latest {{time .Time}}{{end}}</li>
+{{- end}}
+</ul>
+{{- else}}
+<div class="nav"><a href="{{.Root}}index.html">Index</a>
+{{- if eq .Kind "tweet"}} | <a href="{{.Back}}">@{{.Profile.User}}</a>{{end}}</div>
+<h1><a href="{{userURL .Profile.User}}">{{.Profile.Name}} (@{{.Profile.User}})</a></h1>
+{{- if and (eq .Kind "timeline") .Profile.Bio}}
+<p class="bio">{{.Profile.Bio}}</p>
+{{- end}}
+{{- range .Tweets}}
+<div class="tweet" id="{{.ID}}">
+<div class="meta">{{.Name}} (@{{.User}})
+{{- if .Retweet}} (retweeted){{end}} -
+<a href="{{if eq $.Kind "timeline"}}{{.Permalink}}{{else}}{{.Href}}{{end}}">{{time .Time}}</a></div>
+<div class="content">{{.HTML}}</div>
+{{- if .Media}}
+<div class="media">Media:
+{{- range $i, $m := .Media}}{{if $i}},{{end}} <a href="{{$m.URL}}">{{$m.Type}}</a>{{end}}</div>
+{{- end}}
+{{- if eq $.Kind "tweet"}}
+<div class="meta">{{.Replies}} replies, {{.Retweets}} retweets, {{.Likes}} likes -
+<a href="{{.Href}}">View on Twitter</a></div>
+{{- end}}
+</div>
+{{- end}}
+{{- if gt .Pages 1}}
+<div class="nav">
+{{- if .Prev}}<a href="{{.Prev}}">Newer</a> {{end}}Page {{.Page}} of {{.Pages}}
+{{- if .Next}} <a href="{{.Next}}">Older</a>{{end}}</div>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// timelinePageName returns the filename of the 1-based timeline page n.
+func timelinePageName(n int) string {
+	if n <= 1 {
+		return "index.html"
+	}
+	return fmt.Sprintf("page-%d.html", n)
+}
+
+// newSiteTweet returns a siteTweet for t.
+// t's content is sanitized again since archives may have been edited or written by older versions.
+func newSiteTweet(t *tweet) (siteTweet, error) {
+	content, err := sanitizeHTML(t.Content)
+	if err != nil {
+		return siteTweet{}, fmt.Errorf("failed sanitizing %v: %v", t.ID, err)
+	}
+	st := siteTweet{
+		tweet:     *t,
+		HTML:      template.HTML(content),
+		Permalink: fmt.Sprintf("status/%d.html", t.ID),
+	}
+	for _, a := range activityAttachments(t) {
+		if a.Type == "Image" || a.Type == "Video" {
+			st.Media = append(st.Media, a)
+		}
+	}
+	return st, nil
+}
+
+// writeSitePage atomically writes page to p, creating its directory if needed.
+func writeSitePage(p string, page *sitePage) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(p, func(w io.Writer) error { return siteTmpl.Execute(w, page) })
+}
+
+// writeSite writes a static HTML site to dir containing the tweets in archives.
+// The site contains an index page listing all users, paginated timelines for each user,
+// and a permalink page for each tweet.
+func writeSite(dir string, archives []*tweetArchive, opts siteOptions) error {
+	if opts.pageSize <= 0 {
+		return errors.New("page size must be positive")
+	}
+	index := sitePage{Title: opts.title, Kind: "index"}
+	for _, ar := range archives {
+		prof := ar.Profile
+		if prof.User == "" {
+			prof.User = ar.User
+		}
+		if prof.Name == "" {
+			prof.Name = prof.User
+		}
+		udir := strings.ToLower(bareUser(ar.User))
+		user := siteUser{Profile: prof, Dir: udir, Count: len(ar.Tweets)}
+
+		pages := (len(ar.Tweets) + opts.pageSize - 1) / opts.pageSize
+		if pages == 0 {
+			pages = 1 // always write an empty timeline page
+		}
+		for pn := 1; pn <= pages; pn++ {
+			page := sitePage{
+				Title:   prof.displayName(),
+				Root:    "../",
+				Kind:    "timeline",
+				Profile: prof,
+				Page:    pn,
+				Pages:   pages,
+			}
+			if pn > 1 {
+				page.Prev = timelinePageName(pn - 1)
+			}
+			if pn < pages {
+				page.Next = timelinePageName(pn + 1)
+			}
+			start := (pn - 1) * opts.pageSize
+			end := start + opts.pageSize
+			if end > len(ar.Tweets) {
+				end = len(ar.Tweets)
+			}
+			for i := start; i < end; i++ {
+				st, err := newSiteTweet(&ar.Tweets[i])
+				if err != nil {
+					return err
+				}
+				page.Tweets = append(page.Tweets, st)
+				if user.Latest == nil || st.Time.After(user.Latest.Time) {
+					user.Latest = &st
+				}
+				if err := writeSitePage(filepath.Join(dir, udir, st.Permalink), &sitePage{
+					Title:   fmt.Sprintf("%v: %v", st.displayName(), st.Title),
+					Root:    "../../",
+					Kind:    "tweet",
+					Profile: prof,
+					Tweets:  []siteTweet{st},
+					Back:    "../" + timelinePageName(pn) + fmt.Sprintf("#%d", st.ID),
+				}); err != nil {
+					return err
+				}
+			}
+			if err := writeSitePage(filepath.Join(dir, udir, timelinePageName(pn)), &page); err != nil {
+				return err
+			}
+		}
+		debugf("Wrote %v tweet(s) in %v page(s) for %v", len(ar.Tweets), pages, ar.User)
+		index.Users = append(index.Users, user)
+	}
+	sort.Slice(index.Users, func(i, j int) bool {
+		return strings.ToLower(index.Users[i].Profile.User) < strings.ToLower(index.Users[j].Profile.User)
+	})
+	return writeSitePage(filepath.Join(dir, "index.html"), &index)
+}
+
+// readArchives reads the archives for users from dir.
+// If users is empty, all archives in dir are read.
+func readArchives(dir string, users []string) ([]*tweetArchive, error) {
+	var paths []string
+	if len(users) == 0 {
+		var err error
+		if paths, err = filepath.Glob(filepath.Join(dir, "*.json")); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, u := range users {
+			paths = append(paths, archivePath(dir, u))
+		}
+	}
+	var archives []*tweetArchive
+	for _, p := range paths {
+		ar, err := readArchive(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading %v: %v", p, err)
+		}
+		if ar.User == "" {
+			ar.User = strings.TrimSuffix(filepath.Base(p), ".json")
+		}
+		archives = append(archives, ar)
+	}
+	return archives, nil
+}
+
+// runSite implements the "site" subcommand. args excludes the subcommand itself.
+func runSite(args []string) error {
+	fs := flag.NewFlagSet("site", flag.ExitOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s site [flag]... <archive-dir> <output-dir>\n", os.Args[0])
+		fmt.Fprintln(out, "Writes a static HTML site containing tweets archived via -archive-dir.")
+		fmt.Fprintln(out, "Flags:")
+		fs.PrintDefaults()
+	}
+	pageSize := fs.Int("page-size", 50, "Maximum number of tweets on each timeline page")
+	title := fs.String("title", "Twitter archive", "Title of index page")
+	users := fs.String("users", "", "Comma-separated users to include (default is all archived users)")
+	fs.BoolVar(&verbose, "verbose", false, "Enable verbose logging")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	archives, err := readArchives(fs.Arg(0), splitUsers(*users))
+	if err != nil {
+		return err
+	}
+	if len(archives) == 0 {
+		return fmt.Errorf("no archives in %v", fs.Arg(0))
+	}
+	return writeSite(fs.Arg(1), archives, siteOptions{title: *title, pageSize: *pageSize})
+}
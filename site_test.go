@@ -0,0 +1,121 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/html"
+)
+
+func TestWriteSite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "twittuh.site_test.")
+	if err != nil {
+		t.Fatal("Failed creating temp dir: ", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tw := func(user string, id int64, content string) tweet {
+		t := testTweet(user, id)
+		t.Name, t.Content = "Name <"+user+">", content
+		return t
+	}
+	const img = `<img src="https://pbs.twimg.com/media/abc?format=jpg&amp;name=small" alt="Image"/>`
+	archives := []*tweetArchive{
+		{
+			User:    "User",
+			Profile: profile{User: "User", Name: "Name <User>"},
+			Tweets: []tweet{
+				tw("User", 3, "<p>third</p>"),
+				tw("User", 2, "<p>second</p>"+img),
+				// Archived content is sanitized again before it's written.
+				tw("User", 1, `<p>first</p><script>alert(1)</script><a href="javascript:alert(1)">link</a>`),
+			},
+		},
+		{User: "empty"},
+	}
+	if err := writeSite(dir, archives, siteOptions{title: "Archive", pageSize: 2}); err != nil {
+		t.Fatal("writeSite failed: ", err)
+	}
+
+	var files []string
+	if err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			rel, _ := filepath.Rel(dir, p)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	}); err != nil {
+		t.Fatal("Failed walking site: ", err)
+	}
+	sort.Strings(files)
+	if diff := cmp.Diff([]string{
+		"empty/index.html",
+		"index.html",
+		"user/index.html",
+		"user/page-2.html",
+		"user/status/1.html",
+		"user/status/2.html",
+		"user/status/3.html",
+	}, files); diff != "" {
+		t.Error("Bad site files:\n" + diff)
+	}
+
+	// Check that all relative links point at existing pages.
+	read := func(rel string) string {
+		b, err := ioutil.ReadFile(filepath.Join(dir, rel))
+		if err != nil {
+			t.Fatal("Failed reading page: ", err)
+		}
+		return string(b)
+	}
+	for _, f := range files {
+		root, err := html.Parse(strings.NewReader(read(f)))
+		if err != nil {
+			t.Fatalf("Failed parsing %v: %v", f, err)
+		}
+		for _, a := range findNodesAll(root, matchFunc("a", "href")) {
+			u, err := url.Parse(getAttr(a, "href"))
+			if err != nil {
+				t.Errorf("%v has bad link %q: %v", f, getAttr(a, "href"), err)
+			} else if u.IsAbs() {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(dir, filepath.Dir(f), u.Path)); err != nil {
+				t.Errorf("%v has broken link %q", f, getAttr(a, "href"))
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		file string
+		want []string
+	}{
+		{"index.html", []string{"<title>Archive</title>", `<a href="user/index.html">Name &lt;User&gt; (@User)</a>: 3 tweet(s)`}},
+		{"user/index.html", []string{"<p>third</p>", "<p>second</p>", `href="page-2.html">Older</a>`,
+			`Media: <a href="https://pbs.twimg.com/media/abc?format=jpg&amp;name=small">Image</a>`}},
+		{"user/page-2.html", []string{"<p>first</p>", `href="index.html">Newer</a>`}},
+		{"user/status/1.html", []string{"<p>first</p><a>link</a>", `href="../page-2.html#1"`, `href="https://twitter.com/User/status/1"`}},
+	} {
+		page := read(tc.file)
+		for _, w := range tc.want {
+			if !strings.Contains(page, w) {
+				t.Errorf("%v doesn't contain %q:\n%s", tc.file, w, page)
+			}
+		}
+	}
+	for _, f := range files {
+		if page := read(f); strings.Contains(page, "alert(1)") {
+			t.Errorf("%v contains unsanitized content:\n%s", f, page)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"strings"
+	texttemplate "text/template"
+)
+
+// feedTemplates holds templates that customize the text written by writeFeed.
+// Nil templates leave the corresponding text unchanged.
+type feedTemplates struct {
+	feedTitle *texttemplate.Template // executed with feedTemplateData
+	feedDesc  *texttemplate.Template // executed with feedTemplateData
+	itemTitle *texttemplate.Template // executed with itemTemplateData
+
+	// The content template is executed with itemTemplateData. It's parsed as an HTML template
+	// for HTML content and as a text template for other content types.
+	htmlContent *htmltemplate.Template
+	textContent *texttemplate.Template
+}
+
+// feedTemplateData is passed to templates for the feed's title and description.
+type feedTemplateData struct {
+	Profile     profile   // first (typically only) user whose timeline is in the feed
+	Profiles    []profile // all users whose timelines are in the feed
+	Title       string    // default feed title
+	Description string    // default feed description
+}
+
+// itemTemplateData is passed to templates for items' titles and content.
+type itemTemplateData struct {
+	Tweet   tweet
+	Profile profile // first (typically only) user whose timeline is in the feed
+	Title   string  // default item title (the tweet's text, not yet truncated)
+
+	// Content contains the item's rendered content in the feed's content type.
+	// For HTML content, its type is html/template.HTML so it won't be escaped.
+	Content interface{}
+}
+
+// templateFuncs contains additional functions that can be called by templates.
+var templateFuncs = map[string]interface{}{
+	"join":     strings.Join,
+	"truncate": truncate,
+}
+
+// loadTemplates reads templates from p, a JSON file with optional "feedTitle", "feedDesc",
+// "itemTitle", and "content" string fields containing Go templates.
+func loadTemplates(p string) (*feedTemplates, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var srcs struct {
+		FeedTitle string `json:"feedTitle"`
+		FeedDesc  string `json:"feedDesc"`
+		ItemTitle string `json:"itemTitle"`
+		Content   string `json:"content"`
+	}
+	if err := json.Unmarshal(b, &srcs); err != nil {
+		return nil, err
+	}
+	return newFeedTemplates(srcs.FeedTitle, srcs.FeedDesc, srcs.ItemTitle, srcs.Content)
+}
+
+// newFeedTemplates parses the supplied template sources. Empty sources are ignored.
+func newFeedTemplates(feedTitle, feedDesc, itemTitle, content string) (*feedTemplates, error) {
+	var ft feedTemplates
+	for _, t := range []struct {
+		dst  **texttemplate.Template
+		name string
+		src  string
+	}{
+		{&ft.feedTitle, "feedTitle", feedTitle},
+		{&ft.feedDesc, "feedDesc", feedDesc},
+		{&ft.itemTitle, "itemTitle", itemTitle},
+		{&ft.textContent, "content", content},
+	} {
+		if t.src == "" {
+			continue
+		}
+		var err error
+		if *t.dst, err = texttemplate.New(t.name).Funcs(templateFuncs).Parse(t.src); err != nil {
+			return nil, fmt.Errorf("bad %v template: %v", t.name, err)
+		}
+	}
+	if content != "" {
+		var err error
+		if ft.htmlContent, err = htmltemplate.New("content").Funcs(templateFuncs).Parse(content); err != nil {
+			return nil, fmt.Errorf("bad content template: %v", err)
+		}
+	}
+	return &ft, nil
+}
+
+// executeText executes tmpl with data and returns the trimmed result.
+// If tmpl is nil, def is returned.
+func executeText(tmpl *texttemplate.Template, data interface{}, def string) (string, error) {
+	if tmpl == nil {
+		return def, nil
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// feedText returns the feed's title and description.
+func (ft *feedTemplates) feedText(data feedTemplateData) (title, desc string, err error) {
+	if ft == nil {
+		return data.Title, data.Description, nil
+	}
+	if title, err = executeText(ft.feedTitle, data, data.Title); err != nil {
+		return "", "", fmt.Errorf("feed title: %v", err)
+	}
+	if desc, err = executeText(ft.feedDesc, data, data.Description); err != nil {
+		return "", "", fmt.Errorf("feed description: %v", err)
+	}
+	return title, desc, nil
+}
+
+// itemText returns an item's title and content. content is the content rendered in type ct.
+func (ft *feedTemplates) itemText(t *tweet, prof profile, content string, ct contentType) (
+	title, newContent string, err error) {
+	if ft == nil {
+		return t.Title, content, nil
+	}
+	data := itemTemplateData{Tweet: *t, Profile: prof, Title: t.Title, Content: content}
+	if title, err = executeText(ft.itemTitle, data, t.Title); err != nil {
+		return "", "", fmt.Errorf("item title: %v", err)
+	}
+
+	switch {
+	case ct == htmlContent && ft.htmlContent != nil:
+		data.Content = htmltemplate.HTML(content)
+		var b strings.Builder
+		if err := ft.htmlContent.Execute(&b, data); err != nil {
+			return "", "", fmt.Errorf("item content: %v", err)
+		}
+		content = b.String()
+	case ct != htmlContent && ft.textContent != nil:
+		if content, err = executeText(ft.textContent, data, content); err != nil {
+			return "", "", fmt.Errorf("item content: %v", err)
+		}
+	}
+	return title, content, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFeedTemplatesItemText(t *testing.T) {
+	ft, err := newFeedTemplates("", "",
+		`{{if .Tweet.Retweet}}RT @{{.Tweet.User}}: {{end}}{{truncate 10 .Title}}`,
+		`<div class="{{.Profile.User}}">{{.Content}}</div><p>{{.Tweet.Likes}} likes & {{.Tweet.Name}}</p>`)
+	if err != nil {
+		t.Fatal("newFeedTemplates failed: ", err)
+	}
+	prof := profile{User: "user"}
+	for _, tc := range []struct {
+		tw                  tweet
+		ct                  contentType
+		content             string
+		wantTitle, wantCont string
+	}{
+		{
+			tweet{User: "user", Name: "A <B>", Title: "Short", Likes: 3}, htmlContent, "<b>Short</b>",
+			"Short", `<div class="user"><b>Short</b></div><p>3 likes & A &lt;B&gt;</p>`,
+		},
+		{
+			tweet{User: "other", Name: "O", Title: "A longer title", Retweet: true}, textContent, "A longer title",
+			"RT @other: A longer …", `<div class="user">A longer title</div><p>0 likes & O</p>`,
+		},
+	} {
+		title, content, err := ft.itemText(&tc.tw, prof, tc.content, tc.ct)
+		if err != nil {
+			t.Errorf("itemText(%+v) failed: %v", tc.tw, err)
+			continue
+		}
+		if title != tc.wantTitle {
+			t.Errorf("itemText(%+v) returned title %q; want %q", tc.tw, title, tc.wantTitle)
+		}
+		if content != tc.wantCont {
+			t.Errorf("itemText(%+v) returned content %q; want %q", tc.tw, content, tc.wantCont)
+		}
+	}
+
+	// Nil templates should leave everything unchanged.
+	var nilTmpl *feedTemplates
+	tw := tweet{Title: "Title"}
+	if title, content, err := nilTmpl.itemText(&tw, prof, "content", htmlContent); err != nil {
+		t.Error("itemText failed for nil templates: ", err)
+	} else if title != "Title" || content != "content" {
+		t.Errorf("itemText for nil templates returned %q and %q", title, content)
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "twittuh.templates_test.")
+	if err != nil {
+		t.Fatal("Failed creating temp dir: ", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "templates.json")
+	if err := ioutil.WriteFile(p, []byte(`{
+  "feedTitle": "Tweets from {{range $i, $p := .Profiles}}{{if $i}} and {{end}}{{$p.Name}}{{end}}",
+  "feedDesc": "{{.Description}} ({{.Profile.Followers}} followers)",
+  "itemTitle": "{{.Tweet.User}}: {{.Title}}"
+}`), 0644); err != nil {
+		t.Fatal("Failed writing templates: ", err)
+	}
+	ft, err := loadTemplates(p)
+	if err != nil {
+		t.Fatal("loadTemplates failed: ", err)
+	}
+
+	profs := []profile{{User: "a", Name: "A", Followers: 10}, {User: "b", Name: "B"}}
+	tw := testTweet("a", 1)
+	tw.Title = "Hi"
+	tweets := []tweet{tw}
+	var b bytes.Buffer
+	opts := feedOptions{pinned: includePinned, contentType: htmlContent, templates: ft}
+	if err := writeFeed(&b, jsonFormat, profs, tweets, opts); err != nil {
+		t.Fatal("writeFeed failed: ", err)
+	}
+	jf := readJSONFeed(t, b.Bytes())
+	if want := "Tweets from A and B"; jf.Title != want {
+		t.Errorf("Feed has title %q; want %q", jf.Title, want)
+	}
+	if want := "Tweets from @a, @b (10 followers)"; jf.Description != want {
+		t.Errorf("Feed has description %q; want %q", jf.Description, want)
+	}
+	if len(jf.Items) != 1 || jf.Items[0].Title != "a: Hi" {
+		t.Errorf("Feed has items %+v; want one titled %q", jf.Items, "a: Hi")
+	}
+
+	if err := ioutil.WriteFile(p, []byte(`{"itemTitle": "{{.Bogus"}`), 0644); err != nil {
+		t.Fatal("Failed writing templates: ", err)
+	}
+	if _, err := loadTemplates(p); err == nil {
+		t.Error("loadTemplates unexpectedly succeeded for bad template")
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import "strings"
+
+// selfReply returns true if t is a reply to only its own author.
+// These are usually later parts of threads.
+func (t *tweet) selfReply() bool {
+	return len(t.ReplyUsers) == 1 && t.ReplyUsers[0] == t.User
+}
+
+// mergeThreads merges chains of consecutive self-replies in tweets into the tweets that
+// started them. tweets should be ordered newest-first, as they appear on the timeline.
+// A self-reply is only merged if it replies to the previous part of the thread (see
+// tweet.ReplyTo). Each merged tweet keeps the ID and link of the thread's first tweet,
+// but its content contains all of the thread's parts in chronological order. The returned
+// slice is also ordered newest-first. tweets is not modified.
+func mergeThreads(tweets []tweet) []tweet {
+	// Copy slices before appending to them so tweets' backing arrays aren't modified.
+	union := func(a, b []string) []string { return appendUnique(append([]string(nil), a...), b...) }
+
+	var merged []tweet // oldest-first
+	for i := len(tweets) - 1; i >= 0; i-- {
+		t := tweets[i]
+		if n := len(merged); n > 0 {
+			prev := &merged[n-1]
+			if t.selfReply() && t.User == prev.User && t.ReplyTo != 0 && t.ReplyTo == prev.lastID() &&
+				!t.Pinned && !prev.Pinned {
+				prev.Thread = append(append([]int64(nil), prev.Thread...), t.ID)
+				prev.Updated = t.Time
+				prev.Content += "<hr>" + t.Content
+				prev.Text += " " + t.Text
+				prev.Hashtags = union(prev.Hashtags, t.Hashtags)
+				prev.Mentions = union(prev.Mentions, t.Mentions)
+				prev.Cashtags = union(prev.Cashtags, t.Cashtags)
+				prev.URLs = union(prev.URLs, t.URLs)
+				prev.Labels = union(prev.Labels, t.Labels)
+				if prev.Card == nil {
+					prev.Card = t.Card
+				}
+				if t.Note != "" {
+					prev.Note = strings.TrimSpace(prev.Note + " " + t.Note)
+				}
+				prev.Truncated = prev.Truncated || t.Truncated
+				prev.Quote = prev.Quote || t.Quote
+				prev.Media = prev.Media || t.Media
+				continue
+			}
+		}
+		merged = append(merged, t)
+	}
+
+	for i, j := 0, len(merged)-1; i < j; i, j = i+1, j-1 {
+		merged[i], merged[j] = merged[j], merged[i]
+	}
+	return merged
+}
+
+// lastID returns the ID of the last tweet that was merged into t by mergeThreads,
+// or t's own ID if nothing was merged.
+func (t *tweet) lastID() int64 {
+	if len(t.Thread) > 0 {
+		return t.Thread[len(t.Thread)-1]
+	}
+	return t.ID
+}
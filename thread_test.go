@@ -0,0 +1,79 @@
+// Copyright 2020 Daniel Erat. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMergeThreads(t *testing.T) {
+	t1 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Minute)
+	t3 := t2.Add(time.Minute)
+	t4 := t3.Add(time.Minute)
+
+	tweets := []tweet{ // newest-first
+		{ID: 5, User: "a", Time: t4, Content: "<p>5</p>", Text: "5"},
+		{ID: 4, User: "a", Time: t3, Content: "<p>4</p>", Text: "4", ReplyUsers: []string{"a"}, ReplyTo: 3},
+		{ID: 3, User: "a", Time: t2, Content: "<p>3</p>", Text: "3", ReplyUsers: []string{"a"}, ReplyTo: 2},
+		{ID: 2, User: "a", Time: t1, Content: "<p>2</p>", Text: "2"},
+		{ID: 1, User: "b", Time: t1, Content: "<p>1</p>", Text: "1"},
+	}
+	want := []tweet{
+		{ID: 5, User: "a", Time: t4, Content: "<p>5</p>", Text: "5"},
+		{ID: 2, User: "a", Time: t1, Content: "<p>2</p><hr><p>3</p><hr><p>4</p>", Text: "2 3 4",
+			Thread: []int64{3, 4}, Updated: t3},
+		{ID: 1, User: "b", Time: t1, Content: "<p>1</p>", Text: "1"},
+	}
+	if diff := cmp.Diff(want, mergeThreads(tweets)); diff != "" {
+		t.Error("mergeThreads returned bad tweets:\n" + diff)
+	}
+
+	for _, tc := range []struct {
+		desc   string
+		tweets []tweet
+	}{
+		{"different users", []tweet{
+			{ID: 2, User: "b", ReplyUsers: []string{"b"}, ReplyTo: 1},
+			{ID: 1, User: "a"},
+		}},
+		{"unknown parent", []tweet{
+			{ID: 2, User: "a", ReplyUsers: []string{"a"}},
+			{ID: 1, User: "a"},
+		}},
+		{"different parent", []tweet{
+			{ID: 3, User: "a", ReplyUsers: []string{"a"}, ReplyTo: 1},
+			{ID: 2, User: "a"},
+		}},
+	} {
+		if diff := cmp.Diff(tc.tweets, mergeThreads(tc.tweets)); diff != "" {
+			t.Errorf("mergeThreads merged tweets with %v:\n%s", tc.desc, diff)
+		}
+	}
+}
+
+func TestMergeThreadsFields(t *testing.T) {
+	card := &linkCard{URL: "https://example.org/"}
+	tweets := []tweet{ // newest-first
+		{ID: 2, User: "a", ReplyUsers: []string{"a"}, ReplyTo: 1, Hashtags: []string{"b"},
+			Labels: []string{sensitiveLabel}, Note: "Note.", Card: card, Truncated: true, Media: true},
+		{ID: 1, User: "a", Hashtags: make([]string, 1, 2), Quote: true},
+	}
+	tweets[1].Hashtags[0] = "a"
+	got := mergeThreads(tweets)
+	want := []tweet{{ID: 1, User: "a", Thread: []int64{2}, Text: " ", Content: "<hr>",
+		Hashtags: []string{"a", "b"}, Labels: []string{sensitiveLabel}, Note: "Note.", Card: card,
+		Truncated: true, Quote: true, Media: true}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error("mergeThreads returned bad tweet:\n" + diff)
+	}
+	// The original tweet's slice shouldn't have been appended to in place.
+	if h := tweets[1].Hashtags[:2]; h[1] != "" {
+		t.Errorf("mergeThreads modified original hashtags: %q", h)
+	}
+}
@@ -6,7 +6,11 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 )
@@ -34,6 +38,35 @@ func bareUser(u string) string {
 	return u
 }
 
+// hasString returns true if list contains s.
+func hasString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// appendUnique appends each of vals to list if it isn't already present.
+func appendUnique(list []string, vals ...string) []string {
+	for _, v := range vals {
+		if !hasString(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// truncate returns s truncated to n runes, with an ellipsis replacing the final rune
+// if s was too long.
+func truncate(n int, s string) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
+
 // userURL returns the canonical URL of the supplied user's timeline.
 func userURL(user string) string {
 	return fmt.Sprintf("%s://%s/%s", defaultScheme, defaultHost, user)
@@ -72,3 +105,30 @@ func absoluteURL(s string) string {
 	u.Host = defaultHost
 	return u.String()
 }
+
+// writeFileAtomic calls write to write data to a temp file and then renames the temp file to p.
+// The old file is preserved if an error occurs. p's mode is preserved if it already exists
+// and otherwise set to defaultMode.
+func writeFileAtomic(p string, write func(w io.Writer) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(p), "."+filepath.Base(p)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // silently fails if we successfully rename temp file
+
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	mode := defaultMode // ioutil.TempFile seems to use 0600 by default
+	if fi, err := os.Stat(p); err == nil {
+		mode = fi.Mode()
+	}
+	if err := os.Chmod(f.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}